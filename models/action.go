@@ -30,26 +30,27 @@ type ActionType int
 
 // Possible action types.
 const (
-	ActionCreateRepo        ActionType = iota + 1 // 1
-	ActionRenameRepo                              // 2
-	ActionStarRepo                                // 3
-	ActionWatchRepo                               // 4
-	ActionCommitRepo                              // 5
-	ActionCreateIssue                             // 6
-	ActionCreatePullRequest                       // 7
-	ActionTransferRepo                            // 8
-	ActionPushTag                                 // 9
-	ActionCommentIssue                            // 10
-	ActionMergePullRequest                        // 11
-	ActionCloseIssue                              // 12
-	ActionReopenIssue                             // 13
-	ActionClosePullRequest                        // 14
-	ActionReopenPullRequest                       // 15
-	ActionDeleteTag                               // 16
-	ActionDeleteBranch                            // 17
-	ActionMirrorSyncPush                          // 18
-	ActionMirrorSyncCreate                        // 19
-	ActionMirrorSyncDelete                        // 20
+	ActionCreateRepo         ActionType = iota + 1 // 1
+	ActionRenameRepo                               // 2
+	ActionStarRepo                                 // 3
+	ActionWatchRepo                                // 4
+	ActionCommitRepo                               // 5
+	ActionCreateIssue                              // 6
+	ActionCreatePullRequest                        // 7
+	ActionTransferRepo                             // 8
+	ActionPushTag                                  // 9
+	ActionCommentIssue                             // 10
+	ActionMergePullRequest                         // 11
+	ActionCloseIssue                               // 12
+	ActionReopenIssue                              // 13
+	ActionClosePullRequest                         // 14
+	ActionReopenPullRequest                        // 15
+	ActionDeleteTag                                // 16
+	ActionDeleteBranch                             // 17
+	ActionMirrorSyncPush                           // 18
+	ActionMirrorSyncCreate                         // 19
+	ActionMirrorSyncDelete                         // 20
+	ActionRemoveCollaborator                       // 21
 )
 
 var (
@@ -724,6 +725,21 @@ func TransferRepoAction(doer, oldOwner *User, repo *Repository) error {
 	return transferRepoAction(x, doer, oldOwner, repo)
 }
 
+func removeCollaboratorAction(e Engine, doer *User, repo *Repository, u *User) error {
+	if err := notifyWatchers(e, &Action{
+		ActUserID: doer.ID,
+		ActUser:   doer,
+		OpType:    ActionRemoveCollaborator,
+		RepoID:    repo.ID,
+		Repo:      repo,
+		IsPrivate: repo.IsPrivate,
+		Content:   u.Name,
+	}); err != nil {
+		return fmt.Errorf("notifyWatchers: %v", err)
+	}
+	return nil
+}
+
 func mergePullRequestAction(e Engine, doer *User, repo *Repository, issue *Issue) error {
 	return notifyWatchers(e, &Action{
 		ActUserID: doer.ID,
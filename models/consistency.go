@@ -5,6 +5,7 @@
 package models
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -164,3 +165,100 @@ func (action *Action) checkForConsistency(t *testing.T) {
 	repo := AssertExistsAndLoadBean(t, &Repository{ID: action.RepoID}).(*Repository)
 	assert.Equal(t, repo.IsPrivate, action.IsPrivate, "action: %+v", action)
 }
+
+// fixtureReference a column of a fixture table referencing the id of a row of
+// another fixture table
+type fixtureReference struct {
+	Table    string
+	Column   string
+	RefTable string
+	// Dangling the referenced ids deliberately missing from the fixtures, for
+	// testing how missing rows are handled
+	Dangling []int64
+}
+
+// fixtureReferences the references between fixture tables checked by
+// AssertFixturesConsistent
+var fixtureReferences = []fixtureReference{
+	{"access", "user_id", "user", nil},
+	{"access", "repo_id", "repository", nil},
+	{"access_token", "uid", "user", nil},
+	{"action", "repo_id", "repository", nil},
+	{"collaboration", "repo_id", "repository", nil},
+	{"collaboration", "user_id", "user", nil},
+	{"comment", "issue_id", "issue", nil},
+	{"comment", "poster_id", "user", nil},
+	{"email_address", "uid", "user", []int64{9999999}},
+	{"follow", "user_id", "user", nil},
+	{"follow", "follow_id", "user", nil},
+	{"issue", "repo_id", "repository", nil},
+	{"issue", "poster_id", "user", nil},
+	{"issue", "milestone_id", "milestone", nil},
+	{"issue_assignees", "issue_id", "issue", nil},
+	{"issue_assignees", "assignee_id", "user", nil},
+	{"issue_label", "issue_id", "issue", nil},
+	{"issue_label", "label_id", "label", nil},
+	{"issue_user", "issue_id", "issue", nil},
+	{"issue_user", "uid", "user", nil},
+	{"issue_watch", "issue_id", "issue", nil},
+	{"issue_watch", "user_id", "user", nil},
+	{"label", "repo_id", "repository", nil},
+	{"milestone", "repo_id", "repository", nil},
+	{"notification", "user_id", "user", nil},
+	{"notification", "repo_id", "repository", nil},
+	{"notification", "issue_id", "issue", nil},
+	{"org_user", "org_id", "user", nil},
+	{"org_user", "uid", "user", nil},
+	{"pull_request", "issue_id", "issue", nil},
+	{"pull_request", "base_repo_id", "repository", nil},
+	{"reaction", "issue_id", "issue", nil},
+	{"reaction", "user_id", "user", nil},
+	{"release", "repo_id", "repository", nil},
+	{"repo_topic", "repo_id", "repository", nil},
+	{"repo_topic", "topic_id", "topic", nil},
+	{"repo_unit", "repo_id", "repository", nil},
+	{"repository", "owner_id", "user", nil},
+	{"review", "issue_id", "issue", []int64{343545, 534543}},
+	{"review", "reviewer_id", "user", []int64{534543}},
+	{"star", "uid", "user", nil},
+	{"star", "repo_id", "repository", nil},
+	{"stopwatch", "issue_id", "issue", nil},
+	{"stopwatch", "user_id", "user", nil},
+	{"team", "org_id", "user", nil},
+	{"team_repo", "team_id", "team", nil},
+	{"team_repo", "repo_id", "repository", nil},
+	{"team_unit", "team_id", "team", nil},
+	{"team_user", "team_id", "team", nil},
+	{"team_user", "uid", "user", nil},
+	{"tracked_time", "issue_id", "issue", nil},
+	{"tracked_time", "user_id", "user", nil},
+	{"watch", "user_id", "user", nil},
+	{"watch", "repo_id", "repository", nil},
+	{"webhook", "repo_id", "repository", nil},
+}
+
+// danglingFixtureReferences returns the distinct values of the column of a
+// fixture reference which have no row in the referenced table and are not
+// expected to be missing
+func danglingFixtureReferences(ref fixtureReference) ([]int64, error) {
+	sess := x.Table(ref.Table).
+		Where(ref.Column + " > 0").
+		And(fmt.Sprintf("%s NOT IN (SELECT id FROM %s)", ref.Column, x.Quote(ref.RefTable)))
+	if len(ref.Dangling) > 0 {
+		sess.NotIn(ref.Column, ref.Dangling)
+	}
+	var ids []int64
+	return ids, sess.Distinct(ref.Column).Find(&ids)
+}
+
+// AssertFixturesConsistent assert that the loaded fixtures have no references
+// to rows missing from the referenced tables
+func AssertFixturesConsistent(t testing.TB) {
+	for _, ref := range fixtureReferences {
+		ids, err := danglingFixtureReferences(ref)
+		if assert.NoError(t, err, "%s.%s", ref.Table, ref.Column) {
+			assert.Empty(t, ids, "%s.%s references missing %s rows",
+				ref.Table, ref.Column, ref.RefTable)
+		}
+	}
+}
@@ -29,7 +29,8 @@ const (
 	mailIssueComment base.TplName = "issue/comment"
 	mailIssueMention base.TplName = "issue/mention"
 
-	mailNotifyCollaborator base.TplName = "notify/collaborator"
+	mailNotifyCollaborator        base.TplName = "notify/collaborator"
+	mailNotifyCollaboratorRemoved base.TplName = "notify/collaborator_removed"
 )
 
 var templates *template.Template
@@ -141,6 +142,30 @@ func SendCollaboratorMail(u, doer *User, repo *Repository) {
 	mailer.SendAsync(msg)
 }
 
+// SendCollaboratorRemovedMail sends mail notification to a removed collaborator.
+func SendCollaboratorRemovedMail(u, doer *User, repo *Repository) {
+	repoName := path.Join(repo.Owner.Name, repo.Name)
+	subject := fmt.Sprintf("%s removed you from %s", doer.DisplayName(), repoName)
+
+	data := map[string]interface{}{
+		"Subject":  subject,
+		"RepoName": repoName,
+		"Link":     repo.HTMLURL(),
+	}
+
+	var content bytes.Buffer
+
+	if err := templates.ExecuteTemplate(&content, string(mailNotifyCollaboratorRemoved), data); err != nil {
+		log.Error(3, "Template: %v", err)
+		return
+	}
+
+	msg := mailer.NewMessage([]string{u.Email}, subject, content.String())
+	msg.Info = fmt.Sprintf("UID: %d, remove collaborator", u.ID)
+
+	mailer.SendAsync(msg)
+}
+
 func composeTplData(subject, body, link string) map[string]interface{} {
 	data := make(map[string]interface{}, 10)
 	data["Subject"] = subject
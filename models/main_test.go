@@ -10,6 +10,59 @@ import (
 func TestFixturesAreConsistent(t *testing.T) {
 	assert.NoError(t, PrepareTestDatabase())
 	CheckConsistencyForAll(t)
+	AssertFixturesConsistent(t)
+}
+
+func TestFixtureTables(t *testing.T) {
+	syncTables, err := fixtureTables([]string{"user", "repository"})
+	assert.NoError(t, err)
+	assert.Len(t, syncTables, 2)
+	assert.IsType(t, &User{}, syncTables[0])
+	assert.IsType(t, &Repository{}, syncTables[1])
+
+	_, err = fixtureTables([]string{"no_such_table"})
+	assert.Error(t, err)
+}
+
+func TestWithTestTransaction(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			WithTestTransaction(t, func(e Engine) {
+				label := &Label{RepoID: 1, Name: "transaction label", Color: "#123456"}
+				exists, err := e.Get(&Label{Name: label.Name})
+				assert.NoError(t, err)
+				assert.False(t, exists)
+
+				_, err = e.Insert(label)
+				assert.NoError(t, err)
+				exists, err = e.Get(&Label{Name: label.Name})
+				assert.NoError(t, err)
+				assert.True(t, exists)
+			})
+		})
+	}
+	AssertNotExistsBean(t, &Label{Name: "transaction label"})
+}
+
+func TestAssertCountWithConditions(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+	expected, err := x.Where("type=?", UserTypeOrganization).Count(&User{})
+	assert.NoError(t, err)
+	assert.NotZero(t, expected)
+	AssertCountWithConditions(t, &User{}, expected, Cond("type=?", UserTypeOrganization))
+	AssertCountWithConditions(t, &User{}, 0, Cond("type=?", UserTypeOrganization), Cond("type=?", UserTypeIndividual))
+}
+
+func TestAssertExistsAndLoadMap(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+	row := AssertExistsAndLoadMap(t, "user", Cond("lower_name = ?", "user2"))
+	assert.Equal(t, "2", row["id"])
+	assert.Equal(t, "user2", row["name"])
+
+	mockT := &testing.T{}
+	assert.Nil(t, AssertExistsAndLoadMap(mockT, "user", Cond("lower_name = ?", "no such user")))
+	assert.True(t, mockT.Failed())
 }
 
 func TestMain(m *testing.M) {
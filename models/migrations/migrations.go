@@ -200,6 +200,8 @@ var migrations = []Migration{
 	NewMigration("add review", addReview),
 	// v73 -> v74
 	NewMigration("add must_change_password column for users table", addMustChangePassword),
+	// v74 -> v75
+	NewMigration("add indexer_max_file_size column for repository table", addIndexerMaxFileSize),
 }
 
 // Migrate database to current version
@@ -0,0 +1,19 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"github.com/go-xorm/xorm"
+)
+
+func addIndexerMaxFileSize(x *xorm.Engine) error {
+	// Repository see models/repo.go
+	type Repository struct {
+		ID                 int64 `xorm:"pk autoincr"`
+		IndexerMaxFileSize int64 `xorm:"NOT NULL DEFAULT 0"`
+	}
+
+	return x.Sync2(new(Repository))
+}
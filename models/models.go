@@ -14,6 +14,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/setting"
@@ -169,8 +170,18 @@ func LoadConfigs() {
 	if !filepath.IsAbs(setting.Indexer.RepoPath) {
 		setting.Indexer.RepoPath = path.Join(setting.AppWorkPath, setting.Indexer.RepoPath)
 	}
+	setting.Indexer.RepoHistoryIndexerEnabled = sec.Key("REPO_HISTORY_INDEXER_ENABLED").MustBool(false)
+	setting.Indexer.RepoHistoryPath = sec.Key("REPO_HISTORY_INDEXER_PATH").MustString(path.Join(setting.AppDataPath, "indexers/repos_history.bleve"))
+	if !filepath.IsAbs(setting.Indexer.RepoHistoryPath) {
+		setting.Indexer.RepoHistoryPath = path.Join(setting.AppWorkPath, setting.Indexer.RepoHistoryPath)
+	}
+	setting.Indexer.RepoIndexerAnalyzer = sec.Key("REPO_INDEXER_ANALYZER").In("default", []string{"default", "english", "cjk"})
+	setting.Indexer.RepoIndexerMetadata = sec.Key("REPO_INDEXER_METADATA").Strings(",")
 	setting.Indexer.UpdateQueueLength = sec.Key("UPDATE_BUFFER_LEN").MustInt(20)
 	setting.Indexer.MaxIndexerFileSize = sec.Key("MAX_FILE_SIZE").MustInt64(1024 * 1024)
+	setting.Indexer.RepoIndexerBatchSize = sec.Key("REPO_INDEXER_BATCH_SIZE").MustInt(16)
+	setting.Indexer.RepoIndexerBatchBytes = sec.Key("REPO_INDEXER_BATCH_BYTES").MustInt64(0)
+	setting.Indexer.SearchTimeout = sec.Key("SEARCH_TIMEOUT").MustDuration(10 * time.Second)
 }
 
 // parsePostgreSQLHostPort parses given input in various forms defined in
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"code.gitea.io/git"
+	"code.gitea.io/gitea/modules/gitrepo"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/markup"
 	"code.gitea.io/gitea/modules/options"
@@ -200,8 +201,11 @@ type Repository struct {
 	BaseRepo      *Repository        `xorm:"-"`
 	Size          int64              `xorm:"NOT NULL DEFAULT 0"`
 	IndexerStatus *RepoIndexerStatus `xorm:"-"`
-	IsFsckEnabled bool               `xorm:"NOT NULL DEFAULT true"`
-	Topics        []string           `xorm:"TEXT JSON"`
+	// IndexerMaxFileSize overrides the global maximum size of files added
+	// to the repo indexer when greater than zero
+	IndexerMaxFileSize int64    `xorm:"NOT NULL DEFAULT 0"`
+	IsFsckEnabled      bool     `xorm:"NOT NULL DEFAULT true"`
+	Topics             []string `xorm:"TEXT JSON"`
 
 	CreatedUnix util.TimeStamp `xorm:"INDEX created"`
 	UpdatedUnix util.TimeStamp `xorm:"INDEX updated"`
@@ -1547,7 +1551,13 @@ func TransferOwnership(doer *User, newOwnerName string, repo *Repository) error
 		}
 	}
 
-	return sess.Commit()
+	if err = sess.Commit(); err != nil {
+		return fmt.Errorf("Commit: %v", err)
+	}
+
+	// the owner is indexed along with each file
+	RebuildRepoIndexer(repo)
+	return nil
 }
 
 // ChangeRepositoryName changes all corresponding setting from old repository name to new one.
@@ -1838,6 +1848,7 @@ func DeleteRepository(doer *User, uid, repoID int64) error {
 
 	// FIXME: Remove repository files should be executed after transaction succeed.
 	repoPath := repo.repoPath(sess)
+	gitrepo.CloseBatchReaders(repoPath)
 	removeAllWithNotice(sess, "Delete repository files", repoPath)
 
 	repo.deleteWiki(sess)
@@ -6,6 +6,8 @@ package models
 
 import (
 	"fmt"
+
+	"code.gitea.io/gitea/modules/setting"
 )
 
 // Collaboration represent the relation between an individual and a repository.
@@ -154,32 +156,123 @@ func (repo *Repository) ChangeCollaborationAccessMode(uid int64, mode AccessMode
 }
 
 // DeleteCollaboration removes collaboration relation between the user and repository.
-func (repo *Repository) DeleteCollaboration(uid int64) (err error) {
-	collaboration := &Collaboration{
-		RepoID: repo.ID,
-		UserID: uid,
+func (repo *Repository) DeleteCollaboration(uid int64) error {
+	_, err := repo.removeCollaborator(nil, uid)
+	return err
+}
+
+// DeleteCollaborationWithNotify removes collaboration relation between the
+// user and repository like DeleteCollaboration, recording the removal in the
+// activity feed. Once the removal is committed, the removed user is notified
+// by mail if notify mail is enabled.
+func (repo *Repository) DeleteCollaborationWithNotify(doer, u *User) error {
+	if removed, err := repo.removeCollaborator(doer, u.ID); err != nil || !removed {
+		return err
+	}
+
+	if setting.Service.EnableNotifyMail {
+		SendCollaboratorRemovedMail(u, doer, repo)
 	}
+	return nil
+}
+
+// removeCollaborator removes the collaboration of the user, recording an
+// action done by doer unless it is nil. It returns false if the user was not
+// a collaborator.
+func (repo *Repository) removeCollaborator(doer *User, uid int64) (bool, error) {
+	sess := x.NewSession()
+	defer sess.Close()
+	if err := sess.Begin(); err != nil {
+		return false, err
+	}
+
+	if deleted, err := repo.deleteCollaboration(sess, uid); err != nil || !deleted {
+		return false, err
+	} else if err = repo.recalculateAccesses(sess); err != nil {
+		return false, err
+	} else if err = repo.reconsiderWatches(sess, uid); err != nil {
+		return false, err
+	}
+
+	if doer != nil {
+		u, err := getUserByID(sess, uid)
+		if err != nil {
+			return false, err
+		} else if err = removeCollaboratorAction(sess, doer, repo, u); err != nil {
+			return false, err
+		}
+	}
+
+	return true, sess.Commit()
+}
 
+// DeleteCollaborations removes the collaborations of the repository for which
+// filter returns true, in a single transaction.
+func (repo *Repository) DeleteCollaborations(filter func(*Collaboration) (bool, error)) (err error) {
 	sess := x.NewSession()
 	defer sess.Close()
 	if err = sess.Begin(); err != nil {
 		return err
 	}
 
-	if has, err := sess.Delete(collaboration); err != nil || has == 0 {
-		return err
-	} else if err = repo.recalculateAccesses(sess); err != nil {
+	collaborations, err := repo.getCollaborations(sess)
+	if err != nil {
+		return fmt.Errorf("getCollaborations: %v", err)
+	}
+
+	var removedUserIDs []int64
+	for _, c := range collaborations {
+		if remove, err := filter(c); err != nil {
+			return err
+		} else if !remove {
+			continue
+		}
+		if _, err = repo.deleteCollaboration(sess, c.UserID); err != nil {
+			return fmt.Errorf("remove collaborator '%d': %v", c.UserID, err)
+		}
+		removedUserIDs = append(removedUserIDs, c.UserID)
+	}
+	if len(removedUserIDs) == 0 {
+		return nil
+	}
+
+	if err = repo.recalculateAccesses(sess); err != nil {
 		return err
 	}
+	for _, uid := range removedUserIDs {
+		if err = repo.reconsiderWatches(sess, uid); err != nil {
+			return err
+		}
+	}
+	return sess.Commit()
+}
 
-	if err = watchRepo(sess, uid, repo.ID, false); err != nil {
+// deleteCollaboration removes the collaboration of the user. Accesses and
+// the user's watches must be reconsidered afterwards.
+func (repo *Repository) deleteCollaboration(e Engine, uid int64) (bool, error) {
+	has, err := e.Delete(&Collaboration{
+		RepoID: repo.ID,
+		UserID: uid,
+	})
+	return has > 0, err
+}
+
+// reconsiderWatches removes the watches of the repository and its issues by
+// a former collaborator, unless they still have access to the repository,
+// e.g. through a team. Anyone can read a public repository, but that does
+// not keep the watches. Accesses must have been recalculated beforehand.
+func (repo *Repository) reconsiderWatches(e Engine, uid int64) error {
+	access := &Access{UserID: uid, RepoID: repo.ID}
+	if has, err := e.Get(access); err != nil {
 		return err
+	} else if has && access.Mode >= AccessModeRead {
+		return nil
 	}
 
-	// Remove all IssueWatches a user has subscribed to in the repository
-	if err := removeIssueWatchersByRepoID(sess, uid, repo.ID); err != nil {
+	if err := watchRepo(e, uid, repo.ID, false); err != nil {
 		return err
 	}
 
-	return sess.Commit()
+	// Remove all IssueWatches a user has subscribed to in the repository
+	return removeIssueWatchersByRepoID(e, uid, repo.ID)
 }
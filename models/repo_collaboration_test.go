@@ -103,3 +103,69 @@ func TestRepository_DeleteCollaboration(t *testing.T) {
 
 	CheckConsistencyFor(t, &Repository{ID: repo.ID})
 }
+
+func TestRepository_DeleteCollaborations(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 3}).(*Repository)
+	assert.NoError(t, repo.GetOwner())
+	assert.NoError(t, repo.DeleteCollaborations(func(c *Collaboration) (bool, error) {
+		return c.Mode == AccessModeRead, nil
+	}))
+	AssertExistsAndLoadBean(t, &Collaboration{RepoID: repo.ID, UserID: 2})
+
+	assert.NoError(t, repo.DeleteCollaborations(func(c *Collaboration) (bool, error) {
+		return c.Mode == AccessModeWrite, nil
+	}))
+	AssertNotExistsBean(t, &Collaboration{RepoID: repo.ID, UserID: 2})
+
+	CheckConsistencyFor(t, &Repository{ID: repo.ID})
+}
+
+func TestRepository_DeleteCollaboration_Watches(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	// user 2 can still read repo 3 through a team of its owner
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 3}).(*Repository)
+	assert.NoError(t, repo.GetOwner())
+	assert.NoError(t, WatchRepo(2, repo.ID, true))
+	assert.NoError(t, repo.DeleteCollaboration(2))
+	AssertNotExistsBean(t, &Collaboration{RepoID: repo.ID, UserID: 2})
+	AssertExistsAndLoadBean(t, &Watch{RepoID: repo.ID, UserID: 2})
+	CheckConsistencyFor(t, &Repository{ID: repo.ID})
+
+	// user 4 can still read the public repo 4, as anyone can, but no longer
+	// has access to it
+	repo = AssertExistsAndLoadBean(t, &Repository{ID: 4}).(*Repository)
+	assert.False(t, repo.IsPrivate)
+	assert.NoError(t, repo.GetOwner())
+	assert.NoError(t, WatchRepo(4, repo.ID, true))
+	assert.NoError(t, repo.DeleteCollaboration(4))
+	AssertNotExistsBean(t, &Collaboration{RepoID: repo.ID, UserID: 4})
+	AssertNotExistsBean(t, &Watch{RepoID: repo.ID, UserID: 4})
+	CheckConsistencyFor(t, &Repository{ID: repo.ID})
+}
+
+func TestRepository_DeleteCollaborationWithNotify(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 4}).(*Repository)
+	assert.NoError(t, repo.GetOwner())
+	doer := repo.Owner
+	u := AssertExistsAndLoadBean(t, &User{ID: 4}).(*User)
+	assert.NoError(t, repo.DeleteCollaborationWithNotify(doer, u))
+	AssertNotExistsBean(t, &Collaboration{RepoID: repo.ID, UserID: u.ID})
+	AssertExistsAndLoadBean(t, &Action{
+		UserID:    doer.ID,
+		ActUserID: doer.ID,
+		OpType:    ActionRemoveCollaborator,
+		RepoID:    repo.ID,
+		Content:   u.Name,
+	})
+
+	// no action is recorded when the user is not a collaborator
+	assert.NoError(t, repo.DeleteCollaborationWithNotify(doer, u))
+	AssertCount(t, &Action{OpType: ActionRemoveCollaborator}, 1)
+
+	CheckConsistencyFor(t, &Repository{ID: repo.ID})
+}
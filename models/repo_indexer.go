@@ -5,17 +5,23 @@
 package models
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"code.gitea.io/git"
 	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/gitrepo"
 	"code.gitea.io/gitea/modules/indexer"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/setting"
 
 	"github.com/ethantkoenig/rupture"
+	"github.com/go-xorm/builder"
 )
 
 // RepoIndexerStatus status of a repo's entry in the repo indexer
@@ -41,6 +47,15 @@ func (repo *Repository) getIndexerStatus() error {
 	return nil
 }
 
+// MaxIndexerFileSize returns the maximum size of the repository's files to be
+// added to the repo indexer
+func (repo *Repository) MaxIndexerFileSize() int64 {
+	if repo.IndexerMaxFileSize > 0 {
+		return repo.IndexerMaxFileSize
+	}
+	return setting.Indexer.MaxIndexerFileSize
+}
+
 func (repo *Repository) updateIndexerStatus(sha string) error {
 	if err := repo.getIndexerStatus(); err != nil {
 		return err
@@ -59,17 +74,117 @@ func (repo *Repository) updateIndexerStatus(sha string) error {
 type repoIndexerOperation struct {
 	repo    *Repository
 	deleted bool
+	rebuild bool
+	// reindex the progress of the reindex the operation is part of, if any
+	reindex *reindexProgress
 }
 
 var repoIndexerOperationQueue chan repoIndexerOperation
 
+// maxLastCommitPathspecs the maximum number of files passed as pathspecs to a
+// single `git log` when looking up last commits, to keep the command line and
+// its output short
+const maxLastCommitPathspecs = 100
+
+var (
+	// repoIndexerResumed is non-nil while the repo indexer is paused, and is
+	// closed when it is resumed
+	repoIndexerResumed     chan struct{}
+	repoIndexerResumedLock sync.RWMutex
+
+	// queuedRepoRebuilds holds the repositories with a rebuild queued but not
+	// yet started, by ID, so repeated requests are only queued once. It holds
+	// the repository of the latest request, which the rebuild indexes.
+	queuedRepoRebuilds     = make(map[int64]*Repository)
+	queuedRepoRebuildsLock sync.Mutex
+
+	// overflowedRepos holds the repositories whose operations did not fit in
+	// the queue, by ID, with nil for repositories to remove from the indexer.
+	// While queueingOverflowedRepos, a single goroutine queues their rebuilds
+	// and removals as there is room, and later operations are added here too,
+	// so that they are applied in order.
+	overflowedRepos         = make(map[int64]*Repository)
+	queueingOverflowedRepos bool
+	overflowedReposLock     sync.Mutex
+)
+
+// PauseRepoIndexer stops the repo indexer from writing to the index until
+// ResumeRepoIndexer is called, including in an operation which is being
+// applied. Operations added in the meantime are kept in the queue, and the
+// repositories of those which do not fit are rebuilt instead, so no changes
+// are lost.
+func PauseRepoIndexer() {
+	repoIndexerResumedLock.Lock()
+	defer repoIndexerResumedLock.Unlock()
+	if repoIndexerResumed == nil {
+		repoIndexerResumed = make(chan struct{})
+		log.Info("Repo indexer paused")
+	}
+}
+
+// ResumeRepoIndexer resumes a paused repo indexer, applying all operations
+// queued while it was paused.
+func ResumeRepoIndexer() {
+	repoIndexerResumedLock.Lock()
+	defer repoIndexerResumedLock.Unlock()
+	if repoIndexerResumed != nil {
+		close(repoIndexerResumed)
+		repoIndexerResumed = nil
+		log.Info("Repo indexer resumed")
+	}
+}
+
+// IsRepoIndexerPaused returns true if the repo indexer is paused
+func IsRepoIndexerPaused() bool {
+	repoIndexerResumedLock.RLock()
+	defer repoIndexerResumedLock.RUnlock()
+	return repoIndexerResumed != nil
+}
+
+// waitRepoIndexerResumed blocks while the repo indexer is paused
+func waitRepoIndexerResumed() {
+	repoIndexerResumedLock.RLock()
+	resumed := repoIndexerResumed
+	repoIndexerResumedLock.RUnlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// pausableRepoIndexerBatch a batch of the repo indexer whose writes, including
+// the flushes when it is full, wait while the repo indexer is paused
+type pausableRepoIndexerBatch struct {
+	rupture.FlushingBatch
+}
+
+func (b pausableRepoIndexerBatch) Index(id string, data interface{}) error {
+	waitRepoIndexerResumed()
+	return b.FlushingBatch.Index(id, data)
+}
+
+func (b pausableRepoIndexerBatch) Delete(id string) error {
+	waitRepoIndexerResumed()
+	return b.FlushingBatch.Delete(id)
+}
+
+func (b pausableRepoIndexerBatch) Flush() error {
+	waitRepoIndexerResumed()
+	return b.FlushingBatch.Flush()
+}
+
+// repoIndexerBatch returns a batch of the repo indexer which waits while it
+// is paused
+func repoIndexerBatch() rupture.FlushingBatch {
+	return pausableRepoIndexerBatch{indexer.RepoIndexerBatch()}
+}
+
 // InitRepoIndexer initialize the repo indexer
 func InitRepoIndexer() {
 	if !setting.Indexer.RepoIndexerEnabled {
 		return
 	}
 	repoIndexerOperationQueue = make(chan repoIndexerOperation, setting.Indexer.UpdateQueueLength)
-	indexer.InitRepoIndexer(populateRepoIndexerAsynchronously)
+	indexer.InitRepoIndexer(populateRepoIndexerAsynchronously, populateRepoHistoryIndexer)
 	go processRepoIndexerOperationQueue()
 }
 
@@ -99,6 +214,14 @@ func populateRepoIndexerAsynchronously() error {
 	return nil
 }
 
+// populateRepoHistoryIndexer populates the history indexer, created after the
+// repo indexer, with the current versions of the files of all repositories,
+// by rebuilding them in the background
+func populateRepoHistoryIndexer() error {
+	log.Info("Populating the repo history indexer with existing repositories")
+	return ReindexAllRepos()
+}
+
 // populateRepoIndexer populate the repo indexer with pre-existing data. This
 // should only be run when the indexer is created for the first time.
 func populateRepoIndexer(maxRepoID int64) {
@@ -129,7 +252,41 @@ func populateRepoIndexer(maxRepoID int64) {
 	log.Info("Done populating the repo indexer with existing repositories")
 }
 
-func updateRepoIndexer(repo *Repository) error {
+// RepoIndexerStatistic counters of the work done by the repo indexer since
+// startup, for monitoring
+type RepoIndexerStatistic struct {
+	// Updates the number of updates of repositories, UpdateErrors those
+	// which failed and UpdateDuration their total duration
+	Updates        int64
+	UpdateErrors   int64
+	UpdateDuration time.Duration
+	// Files the number of indexed files, Bytes the size of their contents
+	Files int64
+	Bytes int64
+}
+
+var repoIndexerStatistic RepoIndexerStatistic
+
+// GetRepoIndexerStatistic returns the counters of the repo indexer
+func GetRepoIndexerStatistic() RepoIndexerStatistic {
+	return RepoIndexerStatistic{
+		Updates:        atomic.LoadInt64(&repoIndexerStatistic.Updates),
+		UpdateErrors:   atomic.LoadInt64(&repoIndexerStatistic.UpdateErrors),
+		UpdateDuration: time.Duration(atomic.LoadInt64((*int64)(&repoIndexerStatistic.UpdateDuration))),
+		Files:          atomic.LoadInt64(&repoIndexerStatistic.Files),
+		Bytes:          atomic.LoadInt64(&repoIndexerStatistic.Bytes),
+	}
+}
+
+func updateRepoIndexer(repo *Repository) (err error) {
+	defer func(start time.Time) {
+		atomic.AddInt64(&repoIndexerStatistic.Updates, 1)
+		atomic.AddInt64((*int64)(&repoIndexerStatistic.UpdateDuration), int64(time.Since(start)))
+		if err != nil {
+			atomic.AddInt64(&repoIndexerStatistic.UpdateErrors, 1)
+		}
+	}(time.Now())
+
 	sha, err := getDefaultBranchSha(repo)
 	if err != nil {
 		return err
@@ -141,9 +298,20 @@ func updateRepoIndexer(repo *Repository) error {
 		return nil
 	}
 
-	batch := indexer.RepoIndexerBatch()
+	if err := fillLastCommits(repo, sha, changes.Updates); err != nil {
+		// the files are still searchable without their last commits
+		log.Error(4, "fillLastCommits: %v", err)
+	}
+
+	batchReader, err := gitrepo.GetBatchReader(repo.RepoPath())
+	if err != nil {
+		return err
+	}
+	defer gitrepo.ReturnBatchReader(batchReader)
+
+	batch := repoIndexerBatch()
 	for _, update := range changes.Updates {
-		if err := addUpdate(update, repo, batch); err != nil {
+		if err := addUpdate(update, repo, batchReader, batch); err != nil {
 			return err
 		}
 	}
@@ -165,8 +333,89 @@ type repoChanges struct {
 }
 
 type fileUpdate struct {
-	Filename string
-	BlobSha  string
+	Filename   string
+	BlobSha    string
+	LastCommit *fileLastCommit
+}
+
+// fileLastCommit the last commit which modified a file
+type fileLastCommit struct {
+	Sha         string
+	AuthorName  string
+	AuthorEmail string
+	CommittedAt int64
+}
+
+// fillLastCommits sets the last commit of each of the updated files, using a
+// `git log` walk per maxLastCommitPathspecs files rather than one git process
+// per file. The files of a failed walk are left without last commit, and the
+// first error is returned.
+func fillLastCommits(repo *Repository, revision string, updates []fileUpdate) error {
+	var firstErr error
+	for start := 0; start < len(updates); start += maxLastCommitPathspecs {
+		end := start + maxLastCommitPathspecs
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if err := fillLastCommitsOf(repo, revision, updates[start:end]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// fillLastCommitsOf sets the last commit of each of the updated files, which
+// are passed as pathspecs to a single `git log`
+func fillLastCommitsOf(repo *Repository, revision string, updates []fileUpdate) error {
+	remaining := make(map[string]*fileUpdate, len(updates))
+	for i := range updates {
+		remaining[updates[i].Filename] = &updates[i]
+	}
+
+	cmd := git.NewCommand("log", "--name-only", "--format=%x00%H%x00%an%x00%ae%x00%ct", revision, "--")
+	for _, update := range updates {
+		cmd.AddArguments(update.Filename)
+	}
+	stdout, err := cmd.RunInDir(repo.RepoPath())
+	if err != nil {
+		return err
+	}
+
+	var current *fileLastCommit
+	for _, line := range strings.Split(stdout, "\n") {
+		if len(remaining) == 0 {
+			break
+		} else if len(line) == 0 {
+			continue
+		} else if line[0] == '\x00' {
+			fields := strings.Split(line[1:], "\x00")
+			if len(fields) != 4 {
+				return fmt.Errorf("Misformatted git log output: %q", line)
+			}
+			committedAt, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return fmt.Errorf("Misformatted git log output: %v", err)
+			}
+			current = &fileLastCommit{
+				Sha:         fields[0],
+				AuthorName:  fields[1],
+				AuthorEmail: fields[2],
+				CommittedAt: committedAt,
+			}
+			continue
+		}
+		filename := line
+		if filename[0] == '"' {
+			if filename, err = strconv.Unquote(filename); err != nil {
+				return err
+			}
+		}
+		if update, ok := remaining[filename]; ok && current != nil {
+			update.LastCommit = current
+			delete(remaining, filename)
+		}
+	}
+	return nil
 }
 
 func getDefaultBranchSha(repo *Repository) (string, error) {
@@ -177,48 +426,86 @@ func getDefaultBranchSha(repo *Repository) (string, error) {
 	return strings.TrimSpace(stdout), nil
 }
 
-// getRepoChanges returns changes to repo since last indexer update
+// getRepoChanges returns changes to repo since last indexer update, or nil if
+// the indexer is already up to date with revision
 func getRepoChanges(repo *Repository, revision string) (*repoChanges, error) {
 	if err := repo.getIndexerStatus(); err != nil {
 		return nil, err
 	}
 
-	if len(repo.IndexerStatus.CommitSha) == 0 {
+	if repo.IndexerStatus.CommitSha == revision {
+		// e.g. a push to another branch than the default one
+		return nil, nil
+	} else if len(repo.IndexerStatus.CommitSha) == 0 {
 		return genesisChanges(repo, revision)
 	}
 	return nonGenesisChanges(repo, revision)
 }
 
-func addUpdate(update fileUpdate, repo *Repository, batch rupture.FlushingBatch) error {
-	stdout, err := git.NewCommand("cat-file", "-s", update.BlobSha).
-		RunInDir(repo.RepoPath())
-	if err != nil {
-		return err
-	}
-	if size, err := strconv.Atoi(strings.TrimSpace(stdout)); err != nil {
-		return fmt.Errorf("Misformatted git cat-file output: %v", err)
-	} else if int64(size) > setting.Indexer.MaxIndexerFileSize {
-		return nil
-	}
-
-	fileContents, err := git.NewCommand("cat-file", "blob", update.BlobSha).
-		RunInDirBytes(repo.RepoPath())
+func addUpdate(update fileUpdate, repo *Repository, batchReader *gitrepo.BatchReader, batch rupture.FlushingBatch) error {
+	object, fileContents, err := batchReader.Read(update.BlobSha, repo.MaxIndexerFileSize())
 	if err != nil {
 		return err
+	} else if fileContents == nil && object.Size > 0 {
+		// the file may have been indexed under a larger limit
+		return addDelete(update.Filename, repo, batch)
 	} else if !base.IsTextFile(fileContents) {
 		return nil
+	} else if isLFSPointer(fileContents) {
+		// the pointer text is meaningless to search for, and the file may
+		// have been indexed before being tracked by LFS
+		return addDelete(update.Filename, repo, batch)
 	}
+	// e.g. UTF-16 files with a byte order mark
+	fileContents = base.ToUTF8WithFallback(fileContents)
 	indexerUpdate := indexer.RepoIndexerUpdate{
 		Filepath: update.Filename,
 		Op:       indexer.RepoIndexerOpUpdate,
 		Data: &indexer.RepoIndexerData{
-			RepoID:  repo.ID,
-			Content: string(fileContents),
+			RepoID:    repo.ID,
+			OwnerID:   repo.OwnerID,
+			Filename:  update.Filename,
+			Content:   string(fileContents),
+			Size:      object.Size,
+			LineCount: lineCount(fileContents),
+			BlobSha:   update.BlobSha,
+			Metadata:  indexer.ExtractRepoMetadata(fileContents),
 		},
 	}
+	if update.LastCommit != nil {
+		indexerUpdate.Data.LastCommitSha = update.LastCommit.Sha
+		indexerUpdate.Data.LastCommitAuthorName = update.LastCommit.AuthorName
+		indexerUpdate.Data.LastCommitAuthorEmail = update.LastCommit.AuthorEmail
+		indexerUpdate.Data.LastCommitUnix = update.LastCommit.CommittedAt
+	}
+	atomic.AddInt64(&repoIndexerStatistic.Files, 1)
+	atomic.AddInt64(&repoIndexerStatistic.Bytes, int64(len(fileContents)))
 	return indexerUpdate.AddToFlushingBatch(batch)
 }
 
+// isLFSPointer returns whether the content is that of a Git LFS pointer file
+func isLFSPointer(content []byte) bool {
+	if !bytes.HasPrefix(content, []byte(LFSMetaFileIdentifier+"\n")) {
+		return false
+	}
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		if bytes.HasPrefix(line, []byte(LFSMetaFileOidPrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineCount returns the number of lines in the given content, counting a
+// final line without a trailing newline
+func lineCount(content []byte) int {
+	count := bytes.Count(content, []byte{'\n'})
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		count++
+	}
+	return count
+}
+
 func addDelete(filename string, repo *Repository, batch rupture.FlushingBatch) error {
 	indexerUpdate := indexer.RepoIndexerUpdate{
 		Filepath: filename,
@@ -267,6 +554,7 @@ func nonGenesisChanges(repo *Repository, revision string) (*repoChanges, error)
 		// previous commit sha may have been removed by a force push, so
 		// try rebuilding from scratch
 		log.Warn("git diff: %v", err)
+		waitRepoIndexerResumed()
 		if err = indexer.DeleteRepoFromIndexer(repo.ID); err != nil {
 			return nil, err
 		}
@@ -309,19 +597,91 @@ func nonGenesisChanges(repo *Repository, revision string) (*repoChanges, error)
 	return &changes, err
 }
 
-func processRepoIndexerOperationQueue() {
-	for {
-		op := <-repoIndexerOperationQueue
-		if op.deleted {
-			if err := indexer.DeleteRepoFromIndexer(op.repo.ID); err != nil {
-				log.Error(4, "DeleteRepoFromIndexer: %v", err)
-			}
-		} else {
-			if err := updateRepoIndexer(op.repo); err != nil {
-				log.Error(4, "updateRepoIndexer: %v", err)
+// rebuildRepoIndexer removes all of a repo's files from the indexer and adds
+// them again from scratch
+func rebuildRepoIndexer(repo *Repository) error {
+	// the files are re-added before the stale ones are deleted, so that the
+	// repository stays searchable during the rebuild
+	indexedFilenames, err := indexer.RepoIndexerFilenames(repo.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := x.Delete(&RepoIndexerStatus{RepoID: repo.ID}); err != nil {
+		return err
+	}
+	repo.IndexerStatus = nil
+	if err := updateRepoIndexer(repo); err != nil {
+		return err
+	}
+	if err := deleteStaleFilesFromIndexer(repo, indexedFilenames); err != nil {
+		return err
+	}
+	log.Trace("Rebuilt repo indexer for repository %d", repo.ID)
+	return nil
+}
+
+// deleteStaleFilesFromIndexer deletes the given indexed files of a repository
+// from the indexer if they are not in the revision it was last indexed at
+func deleteStaleFilesFromIndexer(repo *Repository, indexedFilenames []string) error {
+	if len(indexedFilenames) == 0 {
+		return nil
+	} else if err := repo.getIndexerStatus(); err != nil {
+		return err
+	} else if len(repo.IndexerStatus.CommitSha) == 0 {
+		return nil
+	}
+	changes, err := genesisChanges(repo, repo.IndexerStatus.CommitSha)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(changes.Updates))
+	for _, update := range changes.Updates {
+		current[update.Filename] = true
+	}
+	batch := repoIndexerBatch()
+	for _, filename := range indexedFilenames {
+		if !current[filename] {
+			if err = addDelete(filename, repo, batch); err != nil {
+				return err
 			}
 		}
 	}
+	return batch.Flush()
+}
+
+func processRepoIndexerOperationQueue() {
+	processRepoIndexerOperations(repoIndexerOperationQueue, applyRepoIndexerOperation)
+}
+
+// processRepoIndexerOperations applies the operations received from queue,
+// waiting while the repo indexer is paused, until queue is closed
+func processRepoIndexerOperations(queue <-chan repoIndexerOperation, apply func(repoIndexerOperation)) {
+	for op := range queue {
+		waitRepoIndexerResumed()
+		apply(op)
+	}
+}
+
+func applyRepoIndexerOperation(op repoIndexerOperation) {
+	if op.deleted {
+		if err := indexer.DeleteRepoFromIndexer(op.repo.ID); err != nil {
+			log.Error(4, "DeleteRepoFromIndexer: %v", err)
+		}
+		if err := indexer.DeleteRepoFromHistoryIndexer(op.repo.ID); err != nil {
+			log.Error(4, "DeleteRepoFromHistoryIndexer: %v", err)
+		}
+	} else if op.rebuild {
+		if err := rebuildRepoIndexer(repoRebuildStarted(op.repo)); err != nil {
+			log.Error(4, "rebuildRepoIndexer: %v", err)
+		}
+	} else {
+		if err := updateRepoIndexer(op.repo); err != nil {
+			log.Error(4, "updateRepoIndexer: %v", err)
+		}
+	}
+	if op.reindex != nil {
+		op.reindex.repoDone()
+	}
 }
 
 // DeleteRepoFromIndexer remove all of a repository's entries from the indexer
@@ -334,16 +694,262 @@ func UpdateRepoIndexer(repo *Repository) {
 	addOperationToQueue(repoIndexerOperation{repo: repo, deleted: false})
 }
 
+// RebuildRepoIndexer re-add all of a repository's files to the indexer. If a
+// rebuild of the repository is already queued, it is not queued again, but
+// indexes repo as given here, e.g. with its new owner.
+func RebuildRepoIndexer(repo *Repository) {
+	if !setting.Indexer.RepoIndexerEnabled {
+		return
+	}
+	if markRepoRebuildQueued(repo) {
+		addOperationToQueue(repoIndexerOperation{repo: repo, rebuild: true})
+	}
+}
+
+// markRepoRebuildQueued records a requested rebuild of the repository, and
+// returns false if a rebuild of it is already queued
+func markRepoRebuildQueued(repo *Repository) bool {
+	queuedRepoRebuildsLock.Lock()
+	defer queuedRepoRebuildsLock.Unlock()
+	_, queued := queuedRepoRebuilds[repo.ID]
+	queuedRepoRebuilds[repo.ID] = repo
+	return !queued
+}
+
+// repoRebuildStarted marks a queued rebuild of the repository as started, so
+// later changes can queue a new one, and returns the repository of the latest
+// request of the rebuild
+func repoRebuildStarted(repo *Repository) *Repository {
+	queuedRepoRebuildsLock.Lock()
+	defer queuedRepoRebuildsLock.Unlock()
+	if latest, ok := queuedRepoRebuilds[repo.ID]; ok {
+		repo = latest
+		delete(queuedRepoRebuilds, repo.ID)
+	}
+	return repo
+}
+
+// reindexProgress the progress of a reindex of repositories
+type reindexProgress struct {
+	total int64
+	done  int64
+}
+
+// repoDone counts a repository whose reindex is done, logging the progress
+func (p *reindexProgress) repoDone() {
+	done := atomic.AddInt64(&p.done, 1)
+	if done%RepositoryListDefaultPageSize == 0 || done == p.total {
+		log.Info("Reindexed %d/%d repositories", done, p.total)
+	}
+}
+
+// queueReindex queues the rebuild of the repositories with the given IDs, and
+// the removal from the indexer of those which no longer exist. It waits for
+// room in the queue, rather than starting a goroutine per operation.
+func queueReindex(queue chan<- repoIndexerOperation, repoIDs []int64, progress *reindexProgress) error {
+	repos, err := GetRepositoriesMapByIDs(repoIDs)
+	if err != nil {
+		return fmt.Errorf("GetRepositoriesMapByIDs: %v", err)
+	}
+	for _, repoID := range repoIDs {
+		if repo, ok := repos[repoID]; !ok {
+			queue <- repoIndexerOperation{repo: &Repository{ID: repoID}, deleted: true, reindex: progress}
+		} else if markRepoRebuildQueued(repo) {
+			queue <- repoIndexerOperation{repo: repo, rebuild: true, reindex: progress}
+		} else {
+			// the queued rebuild indexes repo
+			progress.repoDone()
+		}
+	}
+	return nil
+}
+
+// ReindexRepos rebuilds the indexer entries of the given repositories, e.g.
+// after upgrading the analyzer. Repositories which no longer exist are removed
+// from the indexer. The repositories are queued in the background, and the
+// progress of the reindex is logged.
+func ReindexRepos(repoIDs []int64) error {
+	if !setting.Indexer.RepoIndexerEnabled {
+		return nil
+	}
+	queue := repoIndexerOperationQueue
+	progress := &reindexProgress{total: int64(len(repoIDs))}
+	go func() {
+		for start := 0; start < len(repoIDs); start += RepositoryListDefaultPageSize {
+			end := start + RepositoryListDefaultPageSize
+			if end > len(repoIDs) {
+				end = len(repoIDs)
+			}
+			if err := queueReindex(queue, repoIDs[start:end], progress); err != nil {
+				log.Error(4, "ReindexRepos: %v", err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// ReindexAllRepos rebuilds the indexer entries of all repositories, like
+// ReindexRepos
+func ReindexAllRepos() error {
+	if !setting.Indexer.RepoIndexerEnabled {
+		return nil
+	}
+	total, err := x.Count(new(Repository))
+	if err != nil {
+		return err
+	}
+	queue := repoIndexerOperationQueue
+	progress := &reindexProgress{total: total}
+	go func() {
+		var lastRepoID int64
+		for {
+			repoIDs := make([]int64, 0, RepositoryListDefaultPageSize)
+			if err := x.Table("repository").Cols("id").
+				Where("id > ?", lastRepoID).
+				OrderBy("id").
+				Limit(RepositoryListDefaultPageSize).
+				Find(&repoIDs); err != nil {
+				log.Error(4, "ReindexAllRepos: %v", err)
+				return
+			} else if len(repoIDs) == 0 {
+				return
+			}
+			if err := queueReindex(queue, repoIDs, progress); err != nil {
+				log.Error(4, "ReindexAllRepos: %v", err)
+				return
+			}
+			lastRepoID = repoIDs[len(repoIDs)-1]
+		}
+	}()
+	return nil
+}
+
+// CheckRepoIndexer reconciles the repo indexer with the database: repositories
+// which no longer exist are removed from the indexer, and repositories which
+// were never indexed are added to it. The repositories are queued in the
+// background.
+func CheckRepoIndexer() error {
+	if !setting.Indexer.RepoIndexerEnabled {
+		return nil
+	}
+	indexedRepoIDs, err := indexer.IndexedRepoIDs()
+	if err != nil {
+		return fmt.Errorf("IndexedRepoIDs: %v", err)
+	}
+	queue := repoIndexerOperationQueue
+	go func() {
+		if err := checkRepoIndexer(queue, indexedRepoIDs); err != nil {
+			log.Error(4, "CheckRepoIndexer: %v", err)
+		}
+	}()
+	return nil
+}
+
+// checkRepoIndexer queues the operations reconciling the repo indexer, which
+// has the files of the repositories with the given IDs, with the database. It
+// waits for room in the queue, rather than starting a goroutine per operation.
+func checkRepoIndexer(queue chan<- repoIndexerOperation, indexedRepoIDs []int64) error {
+	indexed := make(map[int64]bool, len(indexedRepoIDs))
+	var orphans int
+	for start := 0; start < len(indexedRepoIDs); start += RepositoryListDefaultPageSize {
+		end := start + RepositoryListDefaultPageSize
+		if end > len(indexedRepoIDs) {
+			end = len(indexedRepoIDs)
+		}
+		indexedRepos, err := GetRepositoriesMapByIDs(indexedRepoIDs[start:end])
+		if err != nil {
+			return fmt.Errorf("GetRepositoriesMapByIDs: %v", err)
+		}
+		for _, repoID := range indexedRepoIDs[start:end] {
+			indexed[repoID] = true
+			if _, ok := indexedRepos[repoID]; !ok {
+				queue <- repoIndexerOperation{repo: &Repository{ID: repoID}, deleted: true}
+				orphans++
+			}
+		}
+	}
+
+	// repositories with an indexer status were indexed, even if none of
+	// their files are, e.g. because they are all binary
+	var missing int
+	var lastRepoID int64
+	for {
+		repos := make([]*Repository, 0, RepositoryListDefaultPageSize)
+		if err := x.Where(builder.Gt{"id": lastRepoID}).
+			And(builder.NotIn("id", builder.Select("repo_id").From("repo_indexer_status"))).
+			OrderBy("id").
+			Limit(RepositoryListDefaultPageSize).
+			Find(&repos); err != nil {
+			return fmt.Errorf("find unindexed repositories: %v", err)
+		} else if len(repos) == 0 {
+			break
+		}
+		for _, repo := range repos {
+			if !indexed[repo.ID] && !repo.IsBare {
+				queue <- repoIndexerOperation{repo: repo}
+				missing++
+			}
+		}
+		lastRepoID = repos[len(repos)-1].ID
+	}
+
+	log.Info("Repo indexer check: removing %d deleted repositories, adding %d unindexed repositories", orphans, missing)
+	return nil
+}
+
 func addOperationToQueue(op repoIndexerOperation) {
 	if !setting.Indexer.RepoIndexerEnabled {
 		return
 	}
-	select {
-	case repoIndexerOperationQueue <- op:
-		break
-	default:
-		go func() {
-			repoIndexerOperationQueue <- op
-		}()
+	queue := repoIndexerOperationQueue
+	overflowedReposLock.Lock()
+	defer overflowedReposLock.Unlock()
+	if !queueingOverflowedRepos {
+		select {
+		case queue <- op:
+			return
+		default:
+		}
+	}
+
+	// the operation is dropped, and its repository rebuilt instead
+	if op.rebuild {
+		op.repo = repoRebuildStarted(op.repo)
+	}
+	if op.deleted {
+		overflowedRepos[op.repo.ID] = nil
+	} else {
+		overflowedRepos[op.repo.ID] = op.repo
+	}
+	if !queueingOverflowedRepos {
+		queueingOverflowedRepos = true
+		go queueOverflowedRepos(queue)
+	}
+}
+
+// queueOverflowedRepos queues the rebuilds and removals of the overflowed
+// repositories, waiting for room in queue, until there are none left
+func queueOverflowedRepos(queue chan<- repoIndexerOperation) {
+	for {
+		overflowedReposLock.Lock()
+		if len(overflowedRepos) == 0 {
+			queueingOverflowedRepos = false
+			overflowedReposLock.Unlock()
+			return
+		}
+		var repoID int64
+		var repo *Repository
+		for repoID, repo = range overflowedRepos {
+			break
+		}
+		delete(overflowedRepos, repoID)
+		overflowedReposLock.Unlock()
+
+		if repo == nil {
+			queue <- repoIndexerOperation{repo: &Repository{ID: repoID}, deleted: true}
+		} else if markRepoRebuildQueued(repo) {
+			queue <- repoIndexerOperation{repo: repo, rebuild: true}
+		}
 	}
 }
@@ -0,0 +1,536 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.gitea.io/git"
+	"code.gitea.io/gitea/modules/gitrepo"
+	"code.gitea.io/gitea/modules/indexer"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseRepoIndexer(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	oldEnabled, oldQueue := setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue = oldEnabled, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	queue := make(chan repoIndexerOperation, 3)
+	repoIndexerOperationQueue = queue
+
+	PauseRepoIndexer()
+	assert.True(t, IsRepoIndexerPaused())
+
+	// run the queue processor, recording applied operations
+	applied := make(chan repoIndexerOperation, cap(queue))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processRepoIndexerOperations(queue, func(op repoIndexerOperation) {
+			applied <- op
+		})
+	}()
+	defer func() {
+		ResumeRepoIndexer()
+		close(queue)
+		<-done
+	}()
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	UpdateRepoIndexer(repo)
+	DeleteRepoFromIndexer(repo)
+	UpdateRepoIndexer(repo)
+
+	select {
+	case op := <-applied:
+		assert.Fail(t, "operation applied while paused", "%+v", op)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ResumeRepoIndexer()
+	assert.False(t, IsRepoIndexerPaused())
+	for i := 0; i < 3; i++ {
+		select {
+		case op := <-applied:
+			assert.EqualValues(t, repo.ID, op.repo.ID)
+		case <-time.After(time.Second):
+			assert.Fail(t, "queued operation not applied after resume")
+			return
+		}
+	}
+}
+
+func TestAddOperationToQueue_Overflow(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	oldEnabled, oldQueue := setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue = oldEnabled, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	repoIndexerOperationQueue = make(chan repoIndexerOperation, 1)
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	deletedRepo := &Repository{ID: 2}
+	defer repoRebuildStarted(repo)
+	UpdateRepoIndexer(repo)
+	for i := 0; i < 100; i++ {
+		UpdateRepoIndexer(repo)
+	}
+	DeleteRepoFromIndexer(deletedRepo)
+
+	// the operations which do not fit are kept once per repository
+	overflowedReposLock.Lock()
+	assert.True(t, len(overflowedRepos) <= 2)
+	overflowedReposLock.Unlock()
+
+	op := receiveRepoIndexerOperation(t)
+	assert.EqualValues(t, repo.ID, op.repo.ID)
+	assert.False(t, op.rebuild)
+
+	// and are replaced by a rebuild or removal of their repository
+	queued := make(map[int64]repoIndexerOperation)
+	for i := 0; i < 2; i++ {
+		op = receiveRepoIndexerOperation(t)
+		queued[op.repo.ID] = op
+	}
+	assert.True(t, queued[repo.ID].rebuild)
+	assert.True(t, queued[deletedRepo.ID].deleted)
+	select {
+	case op = <-repoIndexerOperationQueue:
+		assert.Fail(t, "unexpected operation", "%+v", op)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	overflowedReposLock.Lock()
+	assert.False(t, queueingOverflowedRepos)
+	overflowedReposLock.Unlock()
+}
+
+func TestPauseRepoIndexer_Batch(t *testing.T) {
+	PauseRepoIndexer()
+	defer ResumeRepoIndexer()
+
+	// writes of an operation which started before the pause wait too
+	flushed := make(chan error)
+	go func() {
+		flushed <- pausableRepoIndexerBatch{newRecordingBatch()}.Flush()
+	}()
+	select {
+	case <-flushed:
+		assert.Fail(t, "batch flushed while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ResumeRepoIndexer()
+	select {
+	case err := <-flushed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "batch not flushed after resume")
+	}
+}
+
+func TestRebuildRepoIndexer_Queued(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	oldEnabled, oldQueue := setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue = oldEnabled, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	repoIndexerOperationQueue = make(chan repoIndexerOperation, 3)
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	defer repoRebuildStarted(repo)
+	RebuildRepoIndexer(repo)
+	// e.g. after a transfer of ownership
+	transferred := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	transferred.OwnerID = 3
+	RebuildRepoIndexer(transferred)
+	assert.Len(t, repoIndexerOperationQueue, 1)
+
+	// the started rebuild indexes the repository of the latest request, and
+	// once it has started, a new one may be queued
+	op := <-repoIndexerOperationQueue
+	assert.True(t, op.rebuild)
+	assert.Equal(t, transferred, repoRebuildStarted(op.repo))
+	RebuildRepoIndexer(repo)
+	assert.Len(t, repoIndexerOperationQueue, 1)
+}
+
+func TestFillLastCommits(t *testing.T) {
+	PrepareTestEnv(t)
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	sha, err := getDefaultBranchSha(repo)
+	assert.NoError(t, err)
+
+	updates := []fileUpdate{{Filename: "README.md"}}
+	assert.NoError(t, fillLastCommits(repo, sha, updates))
+	if assert.NotNil(t, updates[0].LastCommit) {
+		assert.Equal(t, "65f1bf27bc3bf70f64657658635e66094edbcb4d", updates[0].LastCommit.Sha)
+		assert.Equal(t, "user1", updates[0].LastCommit.AuthorName)
+		assert.Equal(t, "address1@example.com", updates[0].LastCommit.AuthorEmail)
+		assert.EqualValues(t, 1489956479, updates[0].LastCommit.CommittedAt)
+	}
+
+	// more files than fit in a single git log are looked up in chunks
+	updates = make([]fileUpdate, 2*maxLastCommitPathspecs+1)
+	for i := range updates {
+		updates[i].Filename = fmt.Sprintf("missing%d.md", i)
+	}
+	updates[len(updates)-1].Filename = "README.md"
+	assert.NoError(t, fillLastCommits(repo, sha, updates))
+	assert.Nil(t, updates[0].LastCommit)
+	if assert.NotNil(t, updates[len(updates)-1].LastCommit) {
+		assert.Equal(t, "65f1bf27bc3bf70f64657658635e66094edbcb4d", updates[len(updates)-1].LastCommit.Sha)
+	}
+}
+
+func TestGetRepoChanges(t *testing.T) {
+	PrepareTestEnv(t)
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	sha, err := getDefaultBranchSha(repo)
+	assert.NoError(t, err)
+
+	changes, err := getRepoChanges(repo, sha)
+	assert.NoError(t, err)
+	if assert.NotNil(t, changes) {
+		assert.NotEmpty(t, changes.Updates)
+	}
+
+	assert.NoError(t, repo.updateIndexerStatus(sha))
+	changes, err = getRepoChanges(repo, sha)
+	assert.NoError(t, err)
+	assert.Nil(t, changes)
+}
+
+// recordingBatch a rupture.FlushingBatch which records the operations added to
+// it instead of applying them
+type recordingBatch struct {
+	indexed map[string]interface{}
+	deleted []string
+}
+
+func newRecordingBatch() *recordingBatch {
+	return &recordingBatch{indexed: make(map[string]interface{})}
+}
+
+func (b *recordingBatch) Index(id string, data interface{}) error {
+	b.indexed[id] = data
+	return nil
+}
+
+func (b *recordingBatch) Delete(id string) error {
+	b.deleted = append(b.deleted, id)
+	return nil
+}
+
+func (b *recordingBatch) Flush() error {
+	return nil
+}
+
+func TestAddUpdate(t *testing.T) {
+	PrepareTestEnv(t)
+	oldMaxFileSize := setting.Indexer.MaxIndexerFileSize
+	defer func() { setting.Indexer.MaxIndexerFileSize = oldMaxFileSize }()
+	setting.Indexer.MaxIndexerFileSize = 1024 * 1024
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	batchReader, err := gitrepo.NewBatchReader(repo.RepoPath())
+	assert.NoError(t, err)
+	defer batchReader.Close()
+
+	stats := GetRepoIndexerStatistic()
+	batch := newRecordingBatch()
+	assert.NoError(t, addUpdate(fileUpdate{
+		Filename: "README.md",
+		BlobSha:  "4b4851ad51df6a7d9f25c979345979eaeb5b349f",
+	}, repo, batchReader, batch))
+	assert.EqualValues(t, stats.Files+1, GetRepoIndexerStatistic().Files)
+	assert.EqualValues(t, stats.Bytes+30, GetRepoIndexerStatistic().Bytes)
+
+	if assert.Len(t, batch.indexed, 1) {
+		for _, data := range batch.indexed {
+			indexerData := data.(*indexer.RepoIndexerData)
+			assert.EqualValues(t, repo.ID, indexerData.RepoID)
+			assert.Equal(t, "README.md", indexerData.Filename)
+			assert.EqualValues(t, 30, indexerData.Size)
+			assert.EqualValues(t, 3, indexerData.LineCount)
+		}
+	}
+
+	// a file larger than the repository's limit is removed from the indexer
+	repo.IndexerMaxFileSize = 10
+	batch = newRecordingBatch()
+	assert.NoError(t, addUpdate(fileUpdate{
+		Filename: "README.md",
+		BlobSha:  "4b4851ad51df6a7d9f25c979345979eaeb5b349f",
+	}, repo, batchReader, batch))
+	assert.Len(t, batch.indexed, 0)
+	assert.Len(t, batch.deleted, 1)
+
+	// a MAX_FILE_SIZE of 0 leaves out every file which is not empty
+	repo.IndexerMaxFileSize = 0
+	setting.Indexer.MaxIndexerFileSize = 0
+	batch = newRecordingBatch()
+	assert.NoError(t, addUpdate(fileUpdate{
+		Filename: "README.md",
+		BlobSha:  "4b4851ad51df6a7d9f25c979345979eaeb5b349f",
+	}, repo, batchReader, batch))
+	assert.Len(t, batch.indexed, 0)
+	assert.Len(t, batch.deleted, 1)
+	setting.Indexer.MaxIndexerFileSize = 1024 * 1024
+
+	// LFS pointer files are not indexed
+	pointerSha := hashObject(t, repo, []byte(`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`))
+	repo.IndexerMaxFileSize = 0
+	batch = newRecordingBatch()
+	assert.NoError(t, addUpdate(fileUpdate{
+		Filename: "image.png",
+		BlobSha:  pointerSha,
+	}, repo, batchReader, batch))
+	assert.Len(t, batch.indexed, 0)
+	assert.Len(t, batch.deleted, 1)
+
+	// UTF-16 files are converted to UTF-8: "hey\n" with a BOM
+	utf16Sha := hashObject(t, repo, []byte{0xff, 0xfe, 0x68, 0x00, 0x65, 0x00, 0x79, 0x00, 0x0a, 0x00})
+	batch = newRecordingBatch()
+	assert.NoError(t, addUpdate(fileUpdate{
+		Filename: "windows.txt",
+		BlobSha:  utf16Sha,
+	}, repo, batchReader, batch))
+	if assert.Len(t, batch.indexed, 1) {
+		for _, data := range batch.indexed {
+			indexerData := data.(*indexer.RepoIndexerData)
+			assert.Equal(t, "hey\n", indexerData.Content)
+			assert.EqualValues(t, 1, indexerData.LineCount)
+		}
+	}
+}
+
+// hashObject writes a blob with the given content to the repository
+func hashObject(t *testing.T, repo *Repository, content []byte) string {
+	file, err := ioutil.TempFile("", "blob")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	sha, err := git.NewCommand("hash-object", "-w", file.Name()).RunInDir(repo.RepoPath())
+	assert.NoError(t, err)
+	return strings.TrimSpace(sha)
+}
+
+func TestIsLFSPointer(t *testing.T) {
+	assert.True(t, isLFSPointer([]byte(`version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`)))
+	assert.False(t, isLFSPointer([]byte("version https://git-lfs.github.com/spec/v1\n")))
+	assert.False(t, isLFSPointer([]byte("# README\n\nversion https://git-lfs.github.com/spec/v1\n")))
+	assert.False(t, isLFSPointer([]byte("")))
+}
+
+func TestLineCount(t *testing.T) {
+	assert.Equal(t, 0, lineCount([]byte("")))
+	assert.Equal(t, 1, lineCount([]byte("a")))
+	assert.Equal(t, 1, lineCount([]byte("a\n")))
+	assert.Equal(t, 2, lineCount([]byte("a\nb")))
+	assert.Equal(t, 2, lineCount([]byte("\n\n")))
+}
+
+// receiveRepoIndexerOperation receives an operation from the repo indexer
+// queue, which is filled in the background
+func receiveRepoIndexerOperation(t *testing.T) repoIndexerOperation {
+	select {
+	case op := <-repoIndexerOperationQueue:
+		return op
+	case <-time.After(time.Second):
+		assert.FailNow(t, "no operation queued")
+		return repoIndexerOperation{}
+	}
+}
+
+func TestReindexRepos(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	oldEnabled, oldQueue := setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue = oldEnabled, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	// the repositories are queued as room is made in the queue
+	repoIndexerOperationQueue = make(chan repoIndexerOperation, 1)
+
+	assert.NoError(t, ReindexRepos([]int64{1, NonexistentID}))
+
+	op := receiveRepoIndexerOperation(t)
+	assert.EqualValues(t, 1, op.repo.ID)
+	assert.True(t, op.rebuild)
+	repoRebuildStarted(op.repo)
+	op.reindex.repoDone()
+	op = receiveRepoIndexerOperation(t)
+	assert.EqualValues(t, NonexistentID, op.repo.ID)
+	assert.True(t, op.deleted)
+	op.reindex.repoDone()
+	assert.EqualValues(t, 2, op.reindex.done)
+}
+
+func TestReindexAllRepos(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	oldEnabled, oldQueue := setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer.RepoIndexerEnabled, repoIndexerOperationQueue = oldEnabled, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	repoIndexerOperationQueue = make(chan repoIndexerOperation, 1)
+
+	assert.NoError(t, ReindexAllRepos())
+
+	repoCount := GetCount(t, &Repository{})
+	var lastRepoID int64
+	for i := 0; i < repoCount; i++ {
+		op := receiveRepoIndexerOperation(t)
+		assert.True(t, op.rebuild)
+		assert.True(t, op.repo.ID > lastRepoID)
+		assert.EqualValues(t, repoCount, op.reindex.total)
+		lastRepoID = op.repo.ID
+		repoRebuildStarted(op.repo)
+	}
+}
+
+func TestRebuildRepoIndexer(t *testing.T) {
+	PrepareTestEnv(t)
+
+	dir, err := ioutil.TempDir("", "repo-indexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldIndexer := setting.Indexer
+	defer func() {
+		setting.Indexer = oldIndexer
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	setting.Indexer.RepoHistoryIndexerEnabled = false
+	setting.Indexer.RepoPath = filepath.Join(dir, "repos.bleve")
+	setting.Indexer.RepoIndexerAnalyzer = "default"
+	setting.Indexer.MaxIndexerFileSize = 1024 * 1024
+	indexer.InitRepoIndexer(func() error { return nil }, func() error { return nil })
+	defer func() {
+		assert.NoError(t, indexer.CloseRepoIndexer())
+	}()
+
+	repo := AssertExistsAndLoadBean(t, &Repository{ID: 1}).(*Repository)
+	// the repository is copied again by the next PrepareTestEnv
+	defer gitrepo.CloseBatchReaders(repo.RepoPath())
+	batch := indexer.RepoIndexerBatch()
+	assert.NoError(t, indexer.RepoIndexerUpdate{
+		Filepath: "stale.md",
+		Op:       indexer.RepoIndexerOpUpdate,
+		Data:     &indexer.RepoIndexerData{RepoID: repo.ID, Filename: "stale.md", Content: "stale"},
+	}.AddToFlushingBatch(batch))
+	assert.NoError(t, batch.Flush())
+
+	// the files of the repository are added back, and only the files which
+	// are no longer in it are deleted
+	assert.NoError(t, rebuildRepoIndexer(repo))
+	filenames, err := indexer.RepoIndexerFilenames(repo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"README.md"}, filenames)
+}
+
+func TestCheckRepoIndexer(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	dir, err := ioutil.TempDir("", "repo-indexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldIndexer, oldQueue := setting.Indexer, repoIndexerOperationQueue
+	defer func() {
+		setting.Indexer, repoIndexerOperationQueue = oldIndexer, oldQueue
+	}()
+	setting.Indexer.RepoIndexerEnabled = true
+	setting.Indexer.RepoHistoryIndexerEnabled = false
+	setting.Indexer.RepoPath = filepath.Join(dir, "repos.bleve")
+	setting.Indexer.RepoIndexerAnalyzer = "default"
+	indexer.InitRepoIndexer(func() error { return nil }, func() error { return nil })
+	defer func() {
+		assert.NoError(t, indexer.CloseRepoIndexer())
+	}()
+
+	batch := indexer.RepoIndexerBatch()
+	// bleve stores numbers as float64, so NonexistentID can't be indexed;
+	// there are more deleted repositories than are looked up at once
+	const deletedRepoID = 1000
+	const deletedRepoCount = RepositoryListDefaultPageSize + 1
+	repoIDs := []int64{1}
+	for i := int64(0); i < deletedRepoCount; i++ {
+		repoIDs = append(repoIDs, deletedRepoID+i)
+	}
+	for _, repoID := range repoIDs {
+		assert.NoError(t, indexer.RepoIndexerUpdate{
+			Filepath: "README.md",
+			Op:       indexer.RepoIndexerOpUpdate,
+			Data:     &indexer.RepoIndexerData{RepoID: repoID, Filename: "README.md", Content: "readme"},
+		}.AddToFlushingBatch(batch))
+	}
+	assert.NoError(t, batch.Flush())
+
+	repos := make([]*Repository, 0, 10)
+	assert.NoError(t, x.Find(&repos))
+	repoCount := 0
+	for _, repo := range repos {
+		if !repo.IsBare {
+			repoCount++
+		}
+	}
+	// the operations are queued as there is room for them
+	repoIndexerOperationQueue = make(chan repoIndexerOperation, 1)
+
+	assert.NoError(t, CheckRepoIndexer())
+
+	var op repoIndexerOperation
+	for i := int64(0); i < deletedRepoCount; i++ {
+		op = receiveRepoIndexerOperation(t)
+		assert.EqualValues(t, deletedRepoID+i, op.repo.ID)
+		assert.True(t, op.deleted)
+	}
+	// all other non-bare repositories are missing from the indexer, and are
+	// queued in order
+	var lastRepoID int64
+	for i := 0; i < repoCount-1; i++ {
+		op = receiveRepoIndexerOperation(t)
+		assert.NotEqual(t, int64(1), op.repo.ID)
+		assert.True(t, op.repo.ID > lastRepoID)
+		assert.False(t, op.deleted)
+		assert.False(t, op.repo.IsBare)
+		lastRepoID = op.repo.ID
+	}
+	select {
+	case op = <-repoIndexerOperationQueue:
+		assert.Fail(t, "unexpected operation", "repository %d", op.repo.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -264,8 +264,9 @@ func SearchRepositoryByName(opts *SearchRepoOptions) (RepositoryList, int64, err
 	return repos, count, nil
 }
 
-// FindUserAccessibleRepoIDs find all accessible repositories' ID by user's id
-func FindUserAccessibleRepoIDs(userID int64) ([]int64, error) {
+// userAccessibleRepoCond returns the condition of the repositories accessible
+// by the user, or of the public repositories if userID is not positive
+func userAccessibleRepoCond(userID int64) builder.Cond {
 	var accessCond builder.Cond = builder.Eq{"is_private": false}
 
 	if userID > 0 {
@@ -277,14 +278,32 @@ func FindUserAccessibleRepoIDs(userID int64) ([]int64, error) {
 			),
 		)
 	}
+	return accessCond
+}
 
+// FindUserAccessibleRepoIDs find all accessible repositories' ID by user's id
+func FindUserAccessibleRepoIDs(userID int64) ([]int64, error) {
 	repoIDs := make([]int64, 0, 10)
 	if err := x.
 		Table("repository").
 		Cols("id").
-		Where(accessCond).
+		Where(userAccessibleRepoCond(userID)).
 		Find(&repoIDs); err != nil {
 		return nil, fmt.Errorf("FindUserAccesibleRepoIDs: %v", err)
 	}
 	return repoIDs, nil
 }
+
+// FindUserAccessibleOwnerRepoIDs find the IDs of the repositories of the owner
+// accessible by user's id
+func FindUserAccessibleOwnerRepoIDs(userID, ownerID int64) ([]int64, error) {
+	repoIDs := make([]int64, 0, 10)
+	if err := x.
+		Table("repository").
+		Cols("id").
+		Where(builder.Eq{"owner_id": ownerID}.And(userAccessibleRepoCond(userID))).
+		Find(&repoIDs); err != nil {
+		return nil, fmt.Errorf("FindUserAccessibleOwnerRepoIDs: %v", err)
+	}
+	return repoIDs, nil
+}
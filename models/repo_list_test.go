@@ -250,3 +250,20 @@ func TestSearchRepositoryByTopicName(t *testing.T) {
 		})
 	}
 }
+
+func TestFindUserAccessibleOwnerRepoIDs(t *testing.T) {
+	assert.NoError(t, PrepareTestDatabase())
+
+	// guests only access the public repository of the organization
+	repoIDs, err := FindUserAccessibleOwnerRepoIDs(0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{32}, repoIDs)
+
+	repoIDs, err = FindUserAccessibleOwnerRepoIDs(4, 3)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{3, 32}, repoIDs)
+
+	repoIDs, err = FindUserAccessibleOwnerRepoIDs(4, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, repoIDs, 0)
+}
@@ -6,6 +6,7 @@ package models
 
 import (
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/testfixtures.v2"
@@ -13,10 +14,20 @@ import (
 
 var fixtures *testfixtures.Context
 
-// InitFixtures initialize test fixtures for a test database
-func InitFixtures(helper testfixtures.Helper, dir string) (err error) {
+// InitFixtures initialize test fixtures for a test database. If names are
+// given, only the fixture files of these tables are loaded from dir, instead
+// of all of them.
+func InitFixtures(helper testfixtures.Helper, dir string, names ...string) (err error) {
 	testfixtures.SkipDatabaseNameCheck(true)
-	fixtures, err = testfixtures.NewFolder(x.DB().DB, helper, dir)
+	if len(names) == 0 {
+		fixtures, err = testfixtures.NewFolder(x.DB().DB, helper, dir)
+		return err
+	}
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(dir, name+".yml")
+	}
+	fixtures, err = testfixtures.NewFiles(x.DB().DB, helper, files...)
 	return err
 }
 
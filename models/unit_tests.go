@@ -37,10 +37,18 @@ func fatalTestError(fmtStr string, args ...interface{}) {
 // MainTest a reusable TestMain(..) function for unit tests that need to use a
 // test database. Creates the test database, and sets necessary settings.
 func MainTest(m *testing.M, pathToGiteaRoot string) {
+	MainTestWithFixtures(m, pathToGiteaRoot)
+}
+
+// MainTestWithFixtures is like MainTest, but only syncs the tables of the
+// named fixtures (e.g. "user", "repository") and only loads these fixtures,
+// for faster tests which do not depend on the rest of the test data. All
+// fixtures are loaded if none are named.
+func MainTestWithFixtures(m *testing.M, pathToGiteaRoot string, fixtureNames ...string) {
 	var err error
 	giteaRoot = pathToGiteaRoot
 	fixturesDir := filepath.Join(pathToGiteaRoot, "models", "fixtures")
-	if err = createTestEngine(fixturesDir); err != nil {
+	if err = createTestEngine(fixturesDir, fixtureNames...); err != nil {
 		fatalTestError("Error creating test engine: %v\n", err)
 	}
 
@@ -48,7 +56,6 @@ func MainTest(m *testing.M, pathToGiteaRoot string) {
 	setting.RunUser = "runuser"
 	setting.SSH.Port = 3000
 	setting.SSH.Domain = "try.gitea.io"
-	setting.UseSQLite3 = true
 	setting.RepoRootPath, err = ioutil.TempDir(os.TempDir(), "repos")
 	if err != nil {
 		fatalTestError("TempDir: %v\n", err)
@@ -74,14 +81,48 @@ func MainTest(m *testing.M, pathToGiteaRoot string) {
 	os.Exit(exitStatus)
 }
 
-func createTestEngine(fixturesDir string) error {
-	var err error
-	x, err = xorm.NewEngine("sqlite3", "file::memory:?cache=shared")
+// createTestEngine creates the test database engine. It uses an in-memory
+// SQLite database, unless GITEA_UNIT_TESTS_DB is "mysql" or "postgres", in
+// which case it connects to GITEA_UNIT_TESTS_DSN. That database is shared by
+// the packages under test, so they must not be tested in parallel. If
+// fixtureNames are given, only their tables are synced and loaded.
+func createTestEngine(fixturesDir string, fixtureNames ...string) error {
+	var (
+		err    error
+		helper testfixtures.Helper
+	)
+	switch dbType := os.Getenv("GITEA_UNIT_TESTS_DB"); dbType {
+	case "", "sqlite3":
+		setting.UseSQLite3 = true
+		helper = &testfixtures.SQLite{}
+		x, err = xorm.NewEngine("sqlite3", "file::memory:?cache=shared")
+	case "mysql", "postgres":
+		dsn := os.Getenv("GITEA_UNIT_TESTS_DSN")
+		if len(dsn) == 0 {
+			return fmt.Errorf("GITEA_UNIT_TESTS_DSN must be set to test with %s", dbType)
+		}
+		if dbType == "mysql" {
+			setting.UseMySQL = true
+			helper = &testfixtures.MySQL{}
+		} else {
+			setting.UsePostgreSQL = true
+			helper = &testfixtures.PostgreSQL{}
+		}
+		x, err = xorm.NewEngine(dbType, dsn)
+	default:
+		return fmt.Errorf("unsupported GITEA_UNIT_TESTS_DB: %s", dbType)
+	}
 	if err != nil {
 		return err
 	}
 	x.SetMapper(core.GonicMapper{})
-	if err = x.StoreEngine("InnoDB").Sync2(tables...); err != nil {
+	syncTables := tables
+	if len(fixtureNames) > 0 {
+		if syncTables, err = fixtureTables(fixtureNames); err != nil {
+			return err
+		}
+	}
+	if err = x.StoreEngine("InnoDB").Sync2(syncTables...); err != nil {
 		return err
 	}
 	switch os.Getenv("GITEA_UNIT_TESTS_VERBOSE") {
@@ -89,7 +130,24 @@ func createTestEngine(fixturesDir string) error {
 		x.ShowSQL(true)
 	}
 
-	return InitFixtures(&testfixtures.SQLite{}, fixturesDir)
+	return InitFixtures(helper, fixturesDir, fixtureNames...)
+}
+
+// fixtureTables returns the beans of the tables of the named fixtures
+func fixtureTables(fixtureNames []string) ([]interface{}, error) {
+	beans := make(map[string]interface{}, len(tables))
+	for _, bean := range tables {
+		beans[x.TableInfo(bean).Name] = bean
+	}
+	syncTables := make([]interface{}, 0, len(fixtureNames))
+	for _, name := range fixtureNames {
+		bean, ok := beans[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown fixture table: %s", name)
+		}
+		syncTables = append(syncTables, bean)
+	}
+	return syncTables, nil
 }
 
 func removeAllWithRetry(dir string) error {
@@ -118,6 +176,23 @@ func PrepareTestEnv(t testing.TB) {
 	assert.NoError(t, com.CopyDir(metaPath, setting.RepoRootPath))
 }
 
+// WithTestTransaction runs fn inside a database transaction which is rolled
+// back afterwards, so that its changes are not seen by later tests without
+// having to reload the fixtures. fn must make all its queries through e, as
+// the changes are only visible within the transaction and the models
+// functions using the default engine may block on it.
+func WithTestTransaction(t testing.TB, fn func(e Engine)) {
+	sess := x.NewSession()
+	defer sess.Close()
+	if !assert.NoError(t, sess.Begin()) {
+		return
+	}
+	defer func() {
+		assert.NoError(t, sess.Rollback())
+	}()
+	fn(sess)
+}
+
 type testCond struct {
 	query interface{}
 	args  []interface{}
@@ -164,6 +239,22 @@ func AssertExistsAndLoadBean(t testing.TB, bean interface{}, conditions ...inter
 	return bean
 }
 
+// AssertExistsAndLoadMap assert that a row of a table exists and load its
+// column values, for checking fields without a bean
+func AssertExistsAndLoadMap(t testing.TB, table string, conditions ...interface{}) map[string]string {
+	sess := x.NewSession()
+	defer sess.Close()
+	whereConditions(sess, conditions)
+	rows, err := sess.Table(table).Limit(1).QueryString()
+	assert.NoError(t, err)
+	if !assert.Len(t, rows, 1,
+		"Expected to find a row of %s (with conditions %+v), but did not",
+		table, conditions) {
+		return nil
+	}
+	return rows[0]
+}
+
 // GetCount get the count of a bean
 func GetCount(t testing.TB, bean interface{}, conditions ...interface{}) int {
 	sess := x.NewSession()
@@ -200,6 +291,11 @@ func AssertCount(t testing.TB, bean interface{}, expected interface{}) {
 	assert.EqualValues(t, expected, GetCount(t, bean))
 }
 
+// AssertCountWithConditions assert the count of a bean matching conditions
+func AssertCountWithConditions(t testing.TB, bean interface{}, expected interface{}, conditions ...interface{}) {
+	assert.EqualValues(t, expected, GetCount(t, bean, conditions...))
+}
+
 // AssertInt64InRange assert value is in range [low, high]
 func AssertInt64InRange(t testing.TB, low, high, value int64) {
 	assert.True(t, value >= low && value <= high,
@@ -99,6 +99,8 @@ type RepoSettingForm struct {
 	Private       bool
 	EnablePrune   bool
 
+	IndexerMaxFileSize int64
+
 	// Advanced settings
 	EnableWiki                       bool
 	EnableExternalWiki               bool
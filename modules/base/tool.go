@@ -5,6 +5,7 @@
 package base
 
 import (
+	"bytes"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
@@ -30,6 +31,8 @@ import (
 	"github.com/Unknwon/com"
 	"github.com/Unknwon/i18n"
 	"github.com/gogits/chardet"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
 )
 
 // EncodeMD5 encodes string to md5 hex value.
@@ -87,6 +90,43 @@ func DetectEncoding(content []byte) (string, error) {
 	return result.Charset, err
 }
 
+var (
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// ToUTF8WithFallback detects the encoding of content and coverts to UTF-8 if possible
+func ToUTF8WithFallback(content []byte) []byte {
+	var charsetLabel string
+	// a UTF-16 byte order mark is unambiguous, so takes precedence over the
+	// configured ANSI charset
+	if bytes.HasPrefix(content, utf16LEBOM) {
+		charsetLabel, content = "UTF-16LE", content[len(utf16LEBOM):]
+	} else if bytes.HasPrefix(content, utf16BEBOM) {
+		charsetLabel, content = "UTF-16BE", content[len(utf16BEBOM):]
+	} else {
+		var err error
+		charsetLabel, err = DetectEncoding(content)
+		if err != nil || charsetLabel == "UTF-8" {
+			return content
+		}
+	}
+
+	encoding, _ := charset.Lookup(charsetLabel)
+	if encoding == nil {
+		return content
+	}
+
+	// If there is an error, we concatenate the nicely decoded part and the
+	// original left over. This way we won't lose data.
+	result, n, err := transform.Bytes(encoding.NewDecoder(), content)
+	if err != nil {
+		return append(result, content[n:]...)
+	}
+
+	return result
+}
+
 // BasicAuthDecode decode basic auth string
 func BasicAuthDecode(encoded string) (string, string, error) {
 	s, err := base64.StdEncoding.DecodeString(encoded)
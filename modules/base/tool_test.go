@@ -93,6 +93,23 @@ func TestDetectEncoding(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestToUTF8WithFallback(t *testing.T) {
+	assert.Equal(t, []byte("just some ascii"), ToUTF8WithFallback([]byte("just some ascii")))
+
+	// utf-16le with BOM: "hey<accented G>"
+	b := []byte{0xff, 0xfe, 0x68, 0x00, 0x65, 0x00, 0x79, 0x00, 0xf4, 0x01}
+	assert.Equal(t, "hey\u01f4", string(ToUTF8WithFallback(b)))
+
+	// utf-16be with BOM: "hey"
+	b = []byte{0xfe, 0xff, 0x00, 0x68, 0x00, 0x65, 0x00, 0x79}
+	assert.Equal(t, "hey", string(ToUTF8WithFallback(b)))
+
+	// the BOM takes precedence over the ANSI charset
+	setting.Repository.AnsiCharset = "ISO-8859-1"
+	defer func() { setting.Repository.AnsiCharset = "" }()
+	assert.Equal(t, "hey", string(ToUTF8WithFallback(b)))
+}
+
 func TestBasicAuthDecode(t *testing.T) {
 	_, _, err := BasicAuthDecode("?")
 	assert.Equal(t, "illegal base64 data at input byte 0", err.Error())
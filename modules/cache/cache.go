@@ -15,6 +15,37 @@ import (
 
 var conn mc.Cache
 
+// prefixedCache a cache whose keys are all prefixed, so that several instances
+// can share a cache server. Flush still deletes the keys of all instances.
+type prefixedCache struct {
+	mc.Cache
+	prefix string
+}
+
+func (c *prefixedCache) Put(key string, val interface{}, timeout int64) error {
+	return c.Cache.Put(c.prefix+key, val, timeout)
+}
+
+func (c *prefixedCache) Get(key string) interface{} {
+	return c.Cache.Get(c.prefix + key)
+}
+
+func (c *prefixedCache) Delete(key string) error {
+	return c.Cache.Delete(c.prefix + key)
+}
+
+func (c *prefixedCache) Incr(key string) error {
+	return c.Cache.Incr(c.prefix + key)
+}
+
+func (c *prefixedCache) Decr(key string) error {
+	return c.Cache.Decr(c.prefix + key)
+}
+
+func (c *prefixedCache) IsExist(key string) bool {
+	return c.Cache.IsExist(c.prefix + key)
+}
+
 // NewContext start cache service
 func NewContext() error {
 	if setting.CacheService == nil || conn != nil {
@@ -27,7 +58,36 @@ func NewContext() error {
 		AdapterConfig: setting.CacheService.Conn,
 		Interval:      setting.CacheService.Interval,
 	})
-	return err
+	// the adapter is kept even if it failed to start, e.g. while the cache
+	// server is down, so its keys must be prefixed all the same
+	if conn != nil && len(setting.CacheService.Prefix) > 0 {
+		conn = &prefixedCache{Cache: conn, prefix: setting.CacheService.Prefix}
+	}
+	if err != nil {
+		return err
+	}
+	return Ping()
+}
+
+// Ping checks that a value can be stored in and read back from the cache,
+// since the memcache adapter does not connect until it is first used
+func Ping() error {
+	if conn == nil {
+		return nil
+	}
+	const key = "gitea_cache_ping"
+	if err := conn.Put(key, "pong", 60); err != nil {
+		return fmt.Errorf("unable to put a value into the %s cache: %v", setting.CacheService.Adapter, err)
+	}
+	if conn.Get(key) == nil {
+		return fmt.Errorf("unable to get a value from the %s cache", setting.CacheService.Adapter)
+	}
+	return conn.Delete(key)
+}
+
+// GetCache returns the cache service, which is nil until NewContext is called
+func GetCache() mc.Cache {
+	return conn
 }
 
 // GetInt returns key value from cache with callback when no key exists in cache
@@ -0,0 +1,58 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"code.gitea.io/gitea/modules/setting"
+
+	mc "github.com/go-macaron/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixedCache(t *testing.T) {
+	shared, err := mc.NewCacher("memory", mc.Options{Interval: 60})
+	assert.NoError(t, err)
+	first := &prefixedCache{Cache: shared, prefix: "first_"}
+	second := &prefixedCache{Cache: shared, prefix: "second_"}
+
+	assert.NoError(t, first.Put("key", "first value", 60))
+	assert.NoError(t, second.Put("key", "second value", 60))
+	assert.Equal(t, "first value", first.Get("key"))
+	assert.Equal(t, "second value", second.Get("key"))
+	assert.Equal(t, "first value", shared.Get("first_key"))
+	assert.False(t, shared.IsExist("key"))
+
+	assert.NoError(t, first.Delete("key"))
+	assert.False(t, first.IsExist("key"))
+	assert.True(t, second.IsExist("key"))
+}
+
+// unavailableCache a cache adapter failing to start, like one whose server is
+// down
+type unavailableCache struct {
+	*mc.MemoryCacher
+}
+
+func (c *unavailableCache) StartAndGC(opt mc.Options) error {
+	return errors.New("connection refused")
+}
+
+func TestNewContext_PrefixOnStartupError(t *testing.T) {
+	oldConn, oldCacheService := conn, setting.CacheService
+	defer func() {
+		conn, setting.CacheService = oldConn, oldCacheService
+	}()
+	mc.Register("unavailable", &unavailableCache{mc.NewMemoryCacher()})
+
+	conn = nil
+	setting.CacheService = &setting.Cache{Adapter: "unavailable", Prefix: "first_"}
+	assert.Error(t, NewContext())
+	if assert.IsType(t, &prefixedCache{}, conn) {
+		assert.Equal(t, "first_", conn.(*prefixedCache).prefix)
+	}
+}
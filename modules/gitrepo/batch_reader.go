@@ -0,0 +1,244 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gitrepo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/process"
+
+	"code.gitea.io/git"
+)
+
+const (
+	// maxIdleBatchReaders the maximum number of idle batch readers kept per
+	// repository
+	maxIdleBatchReaders = 2
+	// batchReaderIdleTimeout the duration after which an idle batch reader
+	// is closed
+	batchReaderIdleTimeout = time.Minute
+)
+
+// ErrObjectNotExist represents a "ObjectNotExist" kind of error.
+type ErrObjectNotExist struct {
+	ID string
+}
+
+// IsErrObjectNotExist checks if an error is a ErrObjectNotExist.
+func IsErrObjectNotExist(err error) bool {
+	_, ok := err.(ErrObjectNotExist)
+	return ok
+}
+
+func (err ErrObjectNotExist) Error() string {
+	return fmt.Sprintf("object does not exist [id: %s]", err.ID)
+}
+
+// Object the header of a git object read by a BatchReader
+type Object struct {
+	ID   string
+	Type string
+	Size int64
+}
+
+// BatchReader reads git objects of a repository through a single long-running
+// `git cat-file --batch` process, rather than spawning a process per object.
+// A BatchReader must not be used concurrently.
+type BatchReader struct {
+	repoPath string
+	cmd      *exec.Cmd
+	pid      int64
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	broken   bool
+	idle     *time.Timer
+}
+
+// NewBatchReader starts a batch reader for the repository at the given path.
+// Prefer GetBatchReader, which reuses idle readers.
+func NewBatchReader(repoPath string) (*BatchReader, error) {
+	// git.Command can't write to the standard input of the process, so it is
+	// started here, with the global arguments of git commands
+	args := make([]string, 0, len(git.GlobalCommandArgs)+2)
+	args = append(args, git.GlobalCommandArgs...)
+	cmd := exec.Command("git", append(args, "cat-file", "--batch")...)
+	cmd.Dir = repoPath
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start git cat-file: %v", err)
+	}
+	return &BatchReader{
+		repoPath: repoPath,
+		cmd:      cmd,
+		pid:      process.GetManager().Add(fmt.Sprintf("BatchReader: %s", repoPath), cmd),
+		stdin:    stdin,
+		stdout:   bufio.NewReader(stdout),
+	}, nil
+}
+
+// Read reads the object with the given ID. If limit is not negative and the
+// object is larger than limit, its content is skipped and nil content is
+// returned. A read taking longer than git.DefaultCommandExecutionTimeout
+// stops the process and fails.
+func (r *BatchReader) Read(id string, limit int64) (*Object, []byte, error) {
+	timeout := time.AfterFunc(git.DefaultCommandExecutionTimeout, func() {
+		if err := r.cmd.Process.Kill(); err != nil {
+			log.Error(4, "Kill timed out batch reader for %s: %v", r.repoPath, err)
+		}
+	})
+	object, content, err := r.read(id, limit)
+	timeout.Stop()
+	if err != nil && !IsErrObjectNotExist(err) {
+		// the output stream is in an unknown state, so the reader cannot
+		// be reused
+		r.broken = true
+	}
+	return object, content, err
+}
+
+func (r *BatchReader) read(id string, limit int64) (*Object, []byte, error) {
+	if _, err := io.WriteString(r.stdin, id+"\n"); err != nil {
+		return nil, nil, err
+	}
+	header, err := r.stdout.ReadString('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return nil, nil, ErrObjectNotExist{ID: id}
+	} else if len(fields) != 3 {
+		return nil, nil, fmt.Errorf("Misformatted git cat-file output: %q", header)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Misformatted git cat-file output: %v", err)
+	}
+	object := &Object{
+		ID:   fields[0],
+		Type: fields[1],
+		Size: size,
+	}
+
+	var content []byte
+	if limit >= 0 && size > limit {
+		_, err = io.CopyN(ioutil.Discard, r.stdout, size)
+	} else {
+		content = make([]byte, size)
+		_, err = io.ReadFull(r.stdout, content)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// each object's content is followed by a newline
+	if _, err = r.stdout.Discard(1); err != nil {
+		return nil, nil, err
+	}
+	return object, content, nil
+}
+
+// Close stops the underlying git process. Readers checked out by
+// GetBatchReader should be given back with ReturnBatchReader instead.
+func (r *BatchReader) Close() error {
+	defer process.GetManager().Remove(r.pid)
+	if err := r.stdin.Close(); err != nil {
+		return err
+	}
+	return r.cmd.Wait()
+}
+
+var (
+	idleBatchReaders     = make(map[string][]*BatchReader)
+	idleBatchReadersLock sync.Mutex
+)
+
+func closeBatchReader(r *BatchReader) {
+	if err := r.Close(); err != nil {
+		log.Error(4, "Close batch reader for %s: %v", r.repoPath, err)
+	}
+}
+
+// GetBatchReader checks out a batch reader for the repository at the given
+// path, reusing an idle one if possible. The reader must be given back with
+// ReturnBatchReader once it is no longer used.
+func GetBatchReader(repoPath string) (*BatchReader, error) {
+	idleBatchReadersLock.Lock()
+	if readers := idleBatchReaders[repoPath]; len(readers) > 0 {
+		r := readers[len(readers)-1]
+		idleBatchReaders[repoPath] = readers[:len(readers)-1]
+		idleBatchReadersLock.Unlock()
+		r.idle.Stop()
+		return r, nil
+	}
+	idleBatchReadersLock.Unlock()
+	return NewBatchReader(repoPath)
+}
+
+// ReturnBatchReader gives back a batch reader checked out by GetBatchReader,
+// so that it can be reused. Broken readers, and readers in excess of the
+// number of idle readers kept per repository, are closed. Idle readers are
+// closed after a while.
+func ReturnBatchReader(r *BatchReader) {
+	idleBatchReadersLock.Lock()
+	defer idleBatchReadersLock.Unlock()
+	if r.broken || len(idleBatchReaders[r.repoPath]) >= maxIdleBatchReaders {
+		go closeBatchReader(r)
+		return
+	}
+	idleBatchReaders[r.repoPath] = append(idleBatchReaders[r.repoPath], r)
+	r.idle = time.AfterFunc(batchReaderIdleTimeout, func() {
+		if removeIdleBatchReader(r) {
+			closeBatchReader(r)
+		}
+	})
+}
+
+// removeIdleBatchReader removes the reader from the idle readers, returning
+// false if it was not idle
+func removeIdleBatchReader(r *BatchReader) bool {
+	idleBatchReadersLock.Lock()
+	defer idleBatchReadersLock.Unlock()
+	readers := idleBatchReaders[r.repoPath]
+	for i := range readers {
+		if readers[i] == r {
+			idleBatchReaders[r.repoPath] = append(readers[:i], readers[i+1:]...)
+			if len(idleBatchReaders[r.repoPath]) == 0 {
+				delete(idleBatchReaders, r.repoPath)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// CloseBatchReaders closes the idle batch readers of the repository at the
+// given path, e.g. before the repository is deleted.
+func CloseBatchReaders(repoPath string) {
+	idleBatchReadersLock.Lock()
+	readers := idleBatchReaders[repoPath]
+	delete(idleBatchReaders, repoPath)
+	idleBatchReadersLock.Unlock()
+
+	for _, r := range readers {
+		r.idle.Stop()
+		closeBatchReader(r)
+	}
+}
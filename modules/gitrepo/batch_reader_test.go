@@ -0,0 +1,95 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gitrepo
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRepoPath   = "../../integrations/gitea-repositories-meta/user2/repo1.git"
+	testReadmeBlob = "4b4851ad51df6a7d9f25c979345979eaeb5b349f"
+)
+
+func TestBatchReader_Read(t *testing.T) {
+	r, err := NewBatchReader(testRepoPath)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	object, content, err := r.Read(testReadmeBlob, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, testReadmeBlob, object.ID)
+	assert.Equal(t, "blob", object.Type)
+	assert.EqualValues(t, 30, object.Size)
+	assert.Len(t, content, 30)
+
+	// the content of objects larger than the limit is skipped
+	object, content, err = r.Read(testReadmeBlob, 10)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30, object.Size)
+	assert.Nil(t, content)
+
+	// a limit of 0 only reads empty objects
+	object, content, err = r.Read(testReadmeBlob, 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30, object.Size)
+	assert.Nil(t, content)
+
+	_, content, err = r.Read(testReadmeBlob, 30)
+	assert.NoError(t, err)
+	assert.Len(t, content, 30)
+
+	_, _, err = r.Read("0000000000000000000000000000000000000000", -1)
+	assert.True(t, IsErrObjectNotExist(err))
+
+	// the reader is still usable after a missing object
+	_, content, err = r.Read(testReadmeBlob, -1)
+	assert.NoError(t, err)
+	assert.Len(t, content, 30)
+}
+
+func TestGetBatchReader(t *testing.T) {
+	r, err := GetBatchReader(testRepoPath)
+	assert.NoError(t, err)
+	ReturnBatchReader(r)
+
+	r2, err := GetBatchReader(testRepoPath)
+	assert.NoError(t, err)
+	assert.True(t, r == r2, "idle reader is reused")
+	ReturnBatchReader(r2)
+
+	CloseBatchReaders(testRepoPath)
+	idleBatchReadersLock.Lock()
+	assert.Len(t, idleBatchReaders[testRepoPath], 0)
+	idleBatchReadersLock.Unlock()
+}
+
+func BenchmarkBatchReader(b *testing.B) {
+	r, err := NewBatchReader(testRepoPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := r.Read(testReadmeBlob, -1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCatFile(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		cmd := exec.Command("git", "cat-file", "blob", testReadmeBlob)
+		cmd.Dir = testRepoPath
+		if _, err := cmd.Output(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/registry"
+)
+
+const cjkBigramFilterName = "cjkBigram"
+
+func init() {
+	registry.RegisterTokenFilter(cjkBigramFilterName,
+		func(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+			return cjkBigramFilter{}, nil
+		})
+}
+
+// isCJK returns true for the characters of scripts which do not separate
+// words by spaces, or attach particles to them
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// cjkBigramFilter replaces each run of adjacent Chinese, Japanese and Korean
+// characters by its overlapping pairs of characters, e.g. "検索エンジン" by
+// "検索", "索エ", "エン", "ンジ" and "ジン", so that words can be found in
+// text without a dictionary. A run of a single character is kept as is.
+type cjkBigramFilter struct{}
+
+func (cjkBigramFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	output := make(analysis.TokenStream, 0, len(input))
+	var run []*analysis.Token
+	flushRun := func() {
+		if len(run) == 1 {
+			output = append(output, run[0])
+		}
+		for i := 0; i+1 < len(run); i++ {
+			output = append(output, &analysis.Token{
+				Term:  append(append([]byte{}, run[i].Term...), run[i+1].Term...),
+				Start: run[i].Start,
+				End:   run[i+1].End,
+				Type:  analysis.Double,
+			})
+		}
+		run = run[:0]
+	}
+
+	for _, token := range input {
+		if len(run) > 0 && run[len(run)-1].End != token.Start {
+			flushRun()
+		}
+		// the parts of the token between CJK characters are kept as tokens
+		other := -1
+		for offset, r := range string(token.Term) {
+			if !isCJK(r) {
+				if other < 0 {
+					flushRun()
+					other = offset
+				}
+				continue
+			}
+			if other >= 0 {
+				output = append(output, subToken(token, other, offset, token.Type))
+				other = -1
+			}
+			run = append(run, subToken(token, offset, offset+utf8.RuneLen(r), analysis.Ideographic))
+		}
+		if other == 0 {
+			output = append(output, token)
+		} else if other > 0 {
+			output = append(output, subToken(token, other, len(token.Term), token.Type))
+		}
+	}
+	flushRun()
+
+	// pairs take the place of characters, so the positions of phrases are
+	// renumbered
+	for i, token := range output {
+		token.Position = i + 1
+	}
+	return output
+}
+
+// subToken returns the token of the bytes from start to end of the term of a
+// token
+func subToken(token *analysis.Token, start, end int, tokenType analysis.TokenType) *analysis.Token {
+	return &analysis.Token{
+		Term:  token.Term[start:end],
+		Start: token.Start + start,
+		End:   token.Start + end,
+		Type:  tokenType,
+	}
+}
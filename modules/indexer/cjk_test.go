@@ -0,0 +1,36 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJKBigramFilter(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"検索エンジン":      {"検索", "索エ", "エン", "ンジ", "ジン"},
+		"搜索 代码":       {"搜索", "代码"},
+		"検索engine設定":  {"検索", "engine", "設定"},
+		"검색엔진을 설정":    {"검색", "색엔", "엔진", "진을", "설정"},
+		"func 検() {}": {"func", "検"},
+		"maxFileSize": {"maxFileSize"},
+		"a 索引 b":      {"a", "索引", "b"},
+	} {
+		tokens := cjkBigramFilter{}.Filter(unicode.NewUnicodeTokenizer().Tokenize([]byte(input)))
+		terms := make([]string, len(tokens))
+		for i, token := range tokens {
+			terms[i] = string(token.Term)
+			assert.Equal(t, i+1, token.Position, "%q", input)
+			assert.Equal(t, string(token.Term), input[token.Start:token.End], "%q", input)
+		}
+		assert.Equal(t, expected, terms, "%q", input)
+	}
+	assert.Len(t, cjkBigramFilter{}.Filter(analysis.TokenStream{}), 0)
+}
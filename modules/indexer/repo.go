@@ -5,28 +5,97 @@
 package indexer
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/util"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
 	"github.com/blevesearch/bleve/analysis/analyzer/custom"
 	"github.com/blevesearch/bleve/analysis/token/camelcase"
 	"github.com/blevesearch/bleve/analysis/token/lowercase"
-	"github.com/blevesearch/bleve/analysis/token/unique"
+	"github.com/blevesearch/bleve/analysis/token/porter"
 	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/numeric"
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/ethantkoenig/rupture"
 )
 
 const (
-	repoIndexerAnalyzer = "repoIndexerAnalyzer"
-	repoIndexerDocType  = "repoIndexerDocType"
+	repoIndexerAnalyzer         = "repoIndexerAnalyzer"
+	repoIndexerFilenameAnalyzer = "repoIndexerFilenameAnalyzer"
+	repoIndexerDocType          = "repoIndexerDocType"
 
-	repoIndexerLatestVersion = 1
+	// version 2 adds the Filename field, version 3 the last commit fields,
+	// version 4 the Size and LineCount fields, version 5 indexes every
+	// occurrence of terms, version 6 adds the OwnerID field, version 7 the
+	// Metadata fields
+	repoIndexerLatestVersion = 7
+
+	wholeTermTokenizerName = "wholeTerm"
+
+	// repoIndexerAnalyzerKey the internal key of the index storing the
+	// analyzer profile the index was created with
+	repoIndexerAnalyzerKey = "analyzer"
 )
 
+// repoIndexerAnalyzerProfiles the token filters of the analyzer of file
+// contents, by the name of the profile selected by the
+// [indexer] REPO_INDEXER_ANALYZER setting
+var repoIndexerAnalyzerProfiles = map[string][]string{
+	// words, split at case changes and punctuation so that parts of
+	// identifiers can be searched for
+	"default": {unicodeNormalizeName, camelcase.Name, lowercase.Name},
+	// as default, reduced to their English stem so that e.g. "indexing"
+	// matches "indexed", for repositories which are mostly prose
+	"english": {unicodeNormalizeName, camelcase.Name, lowercase.Name, porter.Name},
+	// as default, with runs of Chinese, Japanese and Korean characters
+	// indexed as overlapping pairs of characters, since they are not
+	// separated into words by spaces
+	"cjk": {unicodeNormalizeName, camelcase.Name, lowercase.Name, cjkBigramFilterName},
+}
+
+func init() {
+	registry.RegisterTokenizer(wholeTermTokenizerName,
+		func(config map[string]interface{}, cache *registry.Cache) (analysis.Tokenizer, error) {
+			return wholeTermTokenizer{}, nil
+		})
+}
+
+// wholeTermTokenizer emits its entire input as a single token, so that
+// fields such as file paths can be matched as a whole.
+type wholeTermTokenizer struct{}
+
+func (wholeTermTokenizer) Tokenize(input []byte) analysis.TokenStream {
+	if len(input) == 0 {
+		return analysis.TokenStream{}
+	}
+	return analysis.TokenStream{
+		&analysis.Token{
+			Term:     input,
+			Position: 1,
+			Start:    0,
+			End:      len(input),
+			Type:     analysis.AlphaNumeric,
+		},
+	}
+}
+
 // repoIndexer (thread-safe) index for repository contents
 var repoIndexer bleve.Index
 
@@ -43,8 +112,21 @@ const (
 
 // RepoIndexerData data stored in the repo indexer
 type RepoIndexerData struct {
-	RepoID  int64
-	Content string
+	RepoID    int64
+	OwnerID   int64
+	Filename  string
+	Content   string
+	Size      int64
+	LineCount int
+	// BlobSha identifies the version of the file in the history indexer
+	BlobSha string
+	// Metadata the values of the metadata fields enabled by the settings
+	Metadata map[string]string
+
+	LastCommitSha         string
+	LastCommitAuthorName  string
+	LastCommitAuthorEmail string
+	LastCommitUnix        int64
 }
 
 // Type returns the document type, for bleve's mapping.Classifier interface.
@@ -73,18 +155,36 @@ func (update RepoIndexerUpdate) AddToFlushingBatch(batch rupture.FlushingBatch)
 	return nil
 }
 
-// InitRepoIndexer initialize repo indexer
-func InitRepoIndexer(populateIndexer func() error) {
+// InitRepoIndexer initialize repo indexer. populateIndexer is called if the
+// repo indexer is created, which also populates the history indexer, and
+// populateHistoryIndexer if only the history indexer is created.
+func InitRepoIndexer(populateIndexer, populateHistoryIndexer func() error) {
+	if err := checkRepoMetadataExtractors(); err != nil {
+		log.Fatal(4, "InitRepoIndexer: %v", err)
+	}
+	historyIndexerCreated := false
+	if setting.Indexer.RepoHistoryIndexerEnabled {
+		historyIndexerCreated = initRepoHistoryIndexer()
+	}
+
 	var err error
 	repoIndexer, err = openIndexer(setting.Indexer.RepoPath, repoIndexerLatestVersion)
 	if err != nil {
 		log.Fatal(4, "InitRepoIndexer: %v", err)
 	}
 	if repoIndexer != nil {
+		if err = checkRepoIndexerAnalyzer(repoIndexer, setting.Indexer.RepoPath); err != nil {
+			log.Fatal(4, "InitRepoIndexer: %v", err)
+		}
+		if historyIndexerCreated {
+			if err = populateHistoryIndexer(); err != nil {
+				log.Fatal(4, "PopulateRepoHistoryIndex: %v", err)
+			}
+		}
 		return
 	}
 
-	if err = createRepoIndexer(); err != nil {
+	if repoIndexer, err = createRepoIndexer(setting.Indexer.RepoPath); err != nil {
 		log.Fatal(4, "CreateRepoIndexer: %v", err)
 	}
 	if err = populateIndexer(); err != nil {
@@ -92,35 +192,126 @@ func InitRepoIndexer(populateIndexer func() error) {
 	}
 }
 
-// createRepoIndexer create a repo indexer if one does not already exist
-func createRepoIndexer() error {
-	var err error
+// CloseRepoIndexer closes the repo indexer and the history indexer, if they
+// are open
+func CloseRepoIndexer() error {
+	if repoIndexer != nil {
+		if err := repoIndexer.Close(); err != nil {
+			return err
+		}
+		repoIndexer = nil
+	}
+	if repoHistoryIndexer != nil {
+		if err := repoHistoryIndexer.Close(); err != nil {
+			return err
+		}
+		repoHistoryIndexer = nil
+	}
+	return nil
+}
+
+// checkRepoIndexerAnalyzer checks that a repo indexer was created with the
+// configured analyzer profile, since changing the analyzer of an existing
+// index would make searches miss documents indexed with the previous one.
+func checkRepoIndexerAnalyzer(index bleve.Index, path string) error {
+	analyzer, err := index.GetInternal([]byte(repoIndexerAnalyzerKey))
+	if err != nil {
+		return err
+	} else if len(analyzer) == 0 {
+		// created before the analyzer could be configured
+		analyzer = []byte("default")
+	}
+	if string(analyzer) != setting.Indexer.RepoIndexerAnalyzer {
+		return fmt.Errorf("the repo indexer at %s was created with the %q analyzer, but REPO_INDEXER_ANALYZER is %q; remove the index to rebuild it with the new analyzer",
+			path, analyzer, setting.Indexer.RepoIndexerAnalyzer)
+	}
+	return nil
+}
+
+// createRepoIndexer create a repo indexer at the given path, which must not
+// already exist
+func createRepoIndexer(path string) (bleve.Index, error) {
+	tokenFilters, ok := repoIndexerAnalyzerProfiles[setting.Indexer.RepoIndexerAnalyzer]
+	if !ok {
+		return nil, fmt.Errorf("unknown repo indexer analyzer: %s", setting.Indexer.RepoIndexerAnalyzer)
+	}
+
 	docMapping := bleve.NewDocumentMapping()
 	numericFieldMapping := bleve.NewNumericFieldMapping()
 	numericFieldMapping.IncludeInAll = false
 	docMapping.AddFieldMappingsAt("RepoID", numericFieldMapping)
+	docMapping.AddFieldMappingsAt("OwnerID", numericFieldMapping)
 
 	textFieldMapping := bleve.NewTextFieldMapping()
 	textFieldMapping.IncludeInAll = false
 	docMapping.AddFieldMappingsAt("Content", textFieldMapping)
 
+	filenameFieldMapping := bleve.NewTextFieldMapping()
+	filenameFieldMapping.IncludeInAll = false
+	filenameFieldMapping.Store = false
+	filenameFieldMapping.Analyzer = repoIndexerFilenameAnalyzer
+	docMapping.AddFieldMappingsAt("Filename", filenameFieldMapping)
+
+	// metadata fields are matched as a whole, e.g. license identifiers
+	metadataMapping := bleve.NewDocumentMapping()
+	metadataMapping.DefaultAnalyzer = repoIndexerFilenameAnalyzer
+	docMapping.AddSubDocumentMapping("Metadata", metadataMapping)
+
+	storedTextFieldMapping := bleve.NewTextFieldMapping()
+	storedTextFieldMapping.Index = false
+	storedTextFieldMapping.IncludeTermVectors = false
+	storedTextFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt("LastCommitAuthorName", storedTextFieldMapping)
+	docMapping.AddFieldMappingsAt("LastCommitAuthorEmail", storedTextFieldMapping)
+	docMapping.AddFieldMappingsAt("LastCommitSha", storedTextFieldMapping)
+
+	// only used to identify versions in the history indexer
+	ignoredFieldMapping := bleve.NewTextFieldMapping()
+	ignoredFieldMapping.Index = false
+	ignoredFieldMapping.Store = false
+	ignoredFieldMapping.IncludeTermVectors = false
+	ignoredFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt("BlobSha", ignoredFieldMapping)
+
+	storedNumericFieldMapping := bleve.NewNumericFieldMapping()
+	storedNumericFieldMapping.Index = false
+	storedNumericFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt("LastCommitUnix", storedNumericFieldMapping)
+	docMapping.AddFieldMappingsAt("Size", storedNumericFieldMapping)
+	docMapping.AddFieldMappingsAt("LineCount", storedNumericFieldMapping)
+
 	mapping := bleve.NewIndexMapping()
-	if err = addUnicodeNormalizeTokenFilter(mapping); err != nil {
-		return err
+	if err := addUnicodeNormalizeTokenFilter(mapping); err != nil {
+		return nil, err
 	} else if err = mapping.AddCustomAnalyzer(repoIndexerAnalyzer, map[string]interface{}{
 		"type":          custom.Name,
 		"char_filters":  []string{},
 		"tokenizer":     unicode.Name,
-		"token_filters": []string{unicodeNormalizeName, camelcase.Name, lowercase.Name, unique.Name},
+		"token_filters": tokenFilters,
 	}); err != nil {
-		return err
+		return nil, err
+	} else if err = mapping.AddCustomAnalyzer(repoIndexerFilenameAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"char_filters":  []string{},
+		"tokenizer":     wholeTermTokenizerName,
+		"token_filters": []string{},
+	}); err != nil {
+		return nil, err
 	}
 	mapping.DefaultAnalyzer = repoIndexerAnalyzer
 	mapping.AddDocumentMapping(repoIndexerDocType, docMapping)
 	mapping.AddDocumentMapping("_all", bleve.NewDocumentDisabledMapping())
 
-	repoIndexer, err = bleve.New(setting.Indexer.RepoPath, mapping)
-	return err
+	index, err := bleve.New(path, mapping)
+	if err != nil {
+		return nil, err
+	} else if err = index.SetInternal([]byte(repoIndexerAnalyzerKey),
+		[]byte(setting.Indexer.RepoIndexerAnalyzer)); err != nil {
+		return nil, err
+	}
+	return index, rupture.WriteIndexMetadata(path, &rupture.IndexMetadata{
+		Version: repoIndexerLatestVersion,
+	})
 }
 
 func filenameIndexerID(repoID int64, filename string) string {
@@ -135,9 +326,70 @@ func filenameOfIndexerID(indexerID string) string {
 	return indexerID[index+1:]
 }
 
-// RepoIndexerBatch batch to add updates to
+// repoFlushingBatch a batch of a repo indexer which is flushed once it has
+// setting.Indexer.RepoIndexerBatchSize operations, or once the contents of the
+// files it indexes exceed setting.Indexer.RepoIndexerBatchBytes if positive,
+// so that batches of large files are kept small.
+type repoFlushingBatch struct {
+	index bleve.Index
+	batch *bleve.Batch
+	bytes int64
+}
+
+func newRepoFlushingBatch(index bleve.Index) *repoFlushingBatch {
+	return &repoFlushingBatch{
+		index: index,
+		batch: index.NewBatch(),
+	}
+}
+
+func (b *repoFlushingBatch) Index(id string, data interface{}) error {
+	if err := b.batch.Index(id, data); err != nil {
+		return err
+	}
+	if repoData, ok := data.(*RepoIndexerData); ok {
+		b.bytes += int64(len(repoData.Content))
+	}
+	return b.flushIfFull()
+}
+
+func (b *repoFlushingBatch) Delete(id string) error {
+	b.batch.Delete(id)
+	return b.flushIfFull()
+}
+
+func (b *repoFlushingBatch) flushIfFull() error {
+	maxSize := setting.Indexer.RepoIndexerBatchSize
+	if maxSize <= 0 {
+		maxSize = maxBatchSize
+	}
+	maxBytes := setting.Indexer.RepoIndexerBatchBytes
+	if b.batch.Size() < maxSize && (maxBytes <= 0 || b.bytes < maxBytes) {
+		return nil
+	}
+	return b.Flush()
+}
+
+func (b *repoFlushingBatch) Flush() error {
+	if err := b.index.Batch(b.batch); err != nil {
+		return err
+	}
+	b.batch.Reset()
+	b.bytes = 0
+	return nil
+}
+
+// RepoIndexerBatch batch to add updates to. If the history indexer is
+// enabled, indexed files are also added to it.
 func RepoIndexerBatch() rupture.FlushingBatch {
-	return rupture.NewFlushingBatch(repoIndexer, maxBatchSize)
+	batch := newRepoFlushingBatch(repoIndexer)
+	if repoHistoryIndexer == nil {
+		return batch
+	}
+	return &repoIndexerBatch{
+		current: batch,
+		history: newRepoFlushingBatch(repoHistoryIndexer),
+	}
 }
 
 // DeleteRepoFromIndexer delete all of a repo's files from indexer
@@ -157,68 +409,404 @@ func DeleteRepoFromIndexer(repoID int64) error {
 	return batch.Flush()
 }
 
+// RepoIndexerFilenames returns the names of the files of a repo in the indexer
+func RepoIndexerFilenames(repoID int64) ([]string, error) {
+	query := numericEqualityQuery(repoID, "RepoID")
+	searchRequest := bleve.NewSearchRequestOptions(query, 2147483647, 0, false)
+	result, err := repoIndexer.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, len(result.Hits))
+	for i, hit := range result.Hits {
+		filenames[i] = filenameOfIndexerID(hit.ID)
+	}
+	return filenames, nil
+}
+
+// IndexedRepoIDs returns the IDs of the repos which have files in the indexer
+func IndexedRepoIDs() ([]int64, error) {
+	dict, err := repoIndexer.FieldDict("RepoID")
+	if err != nil {
+		return nil, err
+	}
+	defer dict.Close()
+
+	var repoIDs []int64
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			break
+		}
+		// numeric fields are indexed as several terms of decreasing
+		// precision, only the full precision one is the exact value
+		term := numeric.PrefixCoded(entry.Term)
+		if shift, err := term.Shift(); err != nil || shift != 0 || entry.Count == 0 {
+			continue
+		}
+		value, err := term.Int64()
+		if err != nil {
+			return nil, err
+		}
+		repoIDs = append(repoIDs, int64(numeric.Int64ToFloat64(value)))
+	}
+	return repoIDs, nil
+}
+
+// DefaultMaxFragments the default maximum number of fragments displayed per
+// search result
+const DefaultMaxFragments = 3
+
+// MaxFuzziness the maximum edit distance of fuzzy searches
+const MaxFuzziness = 2
+
+// FuzzinessAuto the fuzziness of searches where the maximum edit distance of
+// each word of the keyword depends on its length
+const FuzzinessAuto = -1
+
+// ParseFuzziness parses the fuzziness of a search, either an edit distance or
+// "AUTO" for FuzzinessAuto. An empty string is no fuzziness.
+func ParseFuzziness(s string) (int, error) {
+	if len(s) == 0 {
+		return 0, nil
+	} else if strings.EqualFold(s, "auto") {
+		return FuzzinessAuto, nil
+	}
+	fuzziness, err := strconv.Atoi(s)
+	if err != nil || fuzziness < 0 {
+		return 0, fmt.Errorf("invalid fuzziness %q: must be an edit distance or AUTO", s)
+	}
+	return fuzziness, nil
+}
+
+// RepoSearchOptions options for searching the repo indexer
+type RepoSearchOptions struct {
+	RepoIDs []int64
+	// OwnerID restricts the results to repositories of the owner if positive,
+	// e.g. to search an organization without listing its repositories
+	OwnerID int64
+	Keyword string
+	// Fuzziness the maximum edit distance, up to MaxFuzziness, between the
+	// words of the keyword and the terms they match, in any order. If
+	// FuzzinessAuto, the distance of each word depends on its length. If
+	// otherwise not positive, the keyword is searched as an exact phrase.
+	Fuzziness int
+	// PathPatterns restricts the results to files whose path matches at least
+	// one of the patterns. Patterns are globs where "*" and "?" do not match
+	// "/" and "**" matches any number of directories. Patterns prefixed with
+	// "!" exclude matching files instead.
+	PathPatterns []string
+	// MaxFragments the maximum number of fragments displayed per result,
+	// DefaultMaxFragments if not positive. It only affects how modules/search
+	// displays results.
+	MaxFragments int
+	// ExpandToBlock expands the snippets of results to the block enclosing
+	// the match, e.g. the function, for languages whose blocks can be
+	// detected. It only affects how modules/search displays results.
+	ExpandToBlock bool
+	// Metadata restricts the results to files with the given values of
+	// metadata fields, by name of the field
+	Metadata map[string]string
+	// AllHistory searches all the indexed versions of files rather than only
+	// their current version; requires the history indexer
+	AllHistory bool
+	// Timeout the maximum duration of the search, setting.Indexer.SearchTimeout
+	// if not positive
+	Timeout  time.Duration
+	Page     int
+	PageSize int
+}
+
+// RepoSearchMatch the location of a match in the content of a search result
+type RepoSearchMatch struct {
+	StartIndex int
+	EndIndex   int
+}
+
 // RepoSearchResult result of performing a search in a repo
 type RepoSearchResult struct {
-	RepoID     int64
+	RepoID int64
+	// StartIndex and EndIndex locate the first match, Matches all of them
 	StartIndex int
 	EndIndex   int
+	Matches    []RepoSearchMatch
 	Filename   string
 	Content    string
+	Size       int64
+	LineCount  int
+
+	LastCommitSha         string
+	LastCommitAuthorName  string
+	LastCommitAuthorEmail string
+	LastCommitUnix        util.TimeStamp
 }
 
-// SearchRepoByKeyword searches for files in the specified repo.
-// Returns the matching file-paths
-func SearchRepoByKeyword(repoIDs []int64, keyword string, page, pageSize int) (int64, []*RepoSearchResult, error) {
-	phraseQuery := bleve.NewMatchPhraseQuery(keyword)
-	phraseQuery.FieldVal = "Content"
-	phraseQuery.Analyzer = repoIndexerAnalyzer
-
-	var indexerQuery query.Query
-	if len(repoIDs) > 0 {
-		var repoQueries = make([]query.Query, 0, len(repoIDs))
-		for _, repoID := range repoIDs {
-			repoQueries = append(repoQueries, numericEqualityQuery(repoID, "RepoID"))
+// globToRegexp converts a path glob into a regular expression that matches
+// the whole path.
+func globToRegexp(glob string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					buf.WriteString("(?:.*/)?")
+				} else {
+					buf.WriteString(".*")
+				}
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
+
+// pathPatternsQueries splits the path patterns into queries which a file must
+// match at least one of, and queries which it must not match.
+func pathPatternsQueries(patterns []string) (includes, excludes []query.Query) {
+	for _, pattern := range patterns {
+		exclude := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/")
+		if len(pattern) == 0 {
+			continue
+		}
+		q := bleve.NewRegexpQuery(globToRegexp(pattern))
+		q.SetField("Filename")
+		if exclude {
+			excludes = append(excludes, q)
+		} else {
+			includes = append(includes, q)
+		}
+	}
+	return includes, excludes
+}
+
+// contentMatches returns the matches in a hit from the locations of its terms,
+// ordered by position. The locations of consecutive terms, e.g. of a phrase,
+// are merged into a single match.
+func contentMatches(termLocations search.TermLocationMap) []RepoSearchMatch {
+	var locations []*search.Location
+	for _, termLocation := range termLocations {
+		locations = append(locations, termLocation...)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].Pos < locations[j].Pos
+	})
+
+	var matches []RepoSearchMatch
+	var lastPos uint64
+	for _, location := range locations {
+		if n := len(matches); n > 0 && location.Pos <= lastPos+1 {
+			matches[n-1].EndIndex = util.Max(matches[n-1].EndIndex, int(location.End))
+		} else {
+			matches = append(matches, RepoSearchMatch{
+				StartIndex: int(location.Start),
+				EndIndex:   int(location.End),
+			})
+		}
+		lastPos = location.Pos
+	}
+	return matches
+}
+
+// repoSearchIndex returns the index to search with the given options, and the
+// function returning the filename of its documents
+func repoSearchIndex(opts *RepoSearchOptions) (bleve.Index, func(string) string, error) {
+	if opts.AllHistory {
+		if repoHistoryIndexer == nil {
+			return nil, nil, errors.New("the repo history indexer is not enabled")
+		}
+		return repoHistoryIndexer, filenameOfHistoryIndexerID, nil
+	}
+	return repoIndexer, filenameOfIndexerID, nil
+}
+
+// autoFuzziness returns the maximum edit distance of the terms matching term
+// in searches with FuzzinessAuto: none up to 2 characters, 1 up to 5
+// characters and MaxFuzziness above
+func autoFuzziness(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return MaxFuzziness
+}
+
+// autoFuzzyMatchQuery matches documents with terms matching every word of the
+// analyzed keyword, in any order, up to the autoFuzziness of each word
+type autoFuzzyMatchQuery struct {
+	keyword  string
+	field    string
+	analyzer string
+}
+
+// Searcher implements query.Query
+func (q *autoFuzzyMatchQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	analyzer := m.AnalyzerNamed(q.analyzer)
+	if analyzer == nil {
+		return nil, fmt.Errorf("no analyzer named '%s' registered", q.analyzer)
+	}
+	tokens := analyzer.Analyze([]byte(q.keyword))
+	if len(tokens) == 0 {
+		return query.NewMatchNoneQuery().Searcher(i, m, options)
+	}
+
+	termQueries := make([]query.Query, 0, len(tokens))
+	for _, token := range tokens {
+		term := string(token.Term)
+		if fuzziness := autoFuzziness(term); fuzziness > 0 {
+			fuzzyQuery := query.NewFuzzyQuery(term)
+			fuzzyQuery.SetFuzziness(fuzziness)
+			fuzzyQuery.SetField(q.field)
+			termQueries = append(termQueries, fuzzyQuery)
+		} else {
+			termQuery := query.NewTermQuery(term)
+			termQuery.SetField(q.field)
+			termQueries = append(termQueries, termQuery)
 		}
+	}
+	return query.NewConjunctionQuery(termQueries).Searcher(i, m, options)
+}
 
-		indexerQuery = bleve.NewConjunctionQuery(
-			bleve.NewDisjunctionQuery(repoQueries...),
-			phraseQuery,
-		)
+// repoSearchQuery returns the query of files matching the search options
+func repoSearchQuery(opts *RepoSearchOptions) query.Query {
+	var keywordQuery query.Query
+	if opts.Fuzziness == FuzzinessAuto {
+		keywordQuery = &autoFuzzyMatchQuery{
+			keyword:  opts.Keyword,
+			field:    "Content",
+			analyzer: repoIndexerAnalyzer,
+		}
+	} else if opts.Fuzziness > 0 {
+		matchQuery := bleve.NewMatchQuery(opts.Keyword)
+		matchQuery.FieldVal = "Content"
+		matchQuery.Analyzer = repoIndexerAnalyzer
+		matchQuery.SetFuzziness(util.Min(opts.Fuzziness, MaxFuzziness))
+		matchQuery.SetOperator(query.MatchQueryOperatorAnd)
+		keywordQuery = matchQuery
 	} else {
-		indexerQuery = phraseQuery
+		phraseQuery := bleve.NewMatchPhraseQuery(opts.Keyword)
+		phraseQuery.FieldVal = "Content"
+		phraseQuery.Analyzer = repoIndexerAnalyzer
+		keywordQuery = phraseQuery
 	}
 
-	from := (page - 1) * pageSize
-	searchRequest := bleve.NewSearchRequestOptions(indexerQuery, pageSize, from, false)
-	searchRequest.Fields = []string{"Content", "RepoID"}
-	searchRequest.IncludeLocations = true
+	musts := []query.Query{keywordQuery}
+	if len(opts.RepoIDs) > 0 {
+		var repoQueries = make([]query.Query, 0, len(opts.RepoIDs))
+		for _, repoID := range opts.RepoIDs {
+			repoQueries = append(repoQueries, numericEqualityQuery(repoID, "RepoID"))
+		}
+		musts = append(musts, bleve.NewDisjunctionQuery(repoQueries...))
+	}
+	if opts.OwnerID > 0 {
+		musts = append(musts, numericEqualityQuery(opts.OwnerID, "OwnerID"))
+	}
+	for name, value := range opts.Metadata {
+		metadataQuery := bleve.NewTermQuery(value)
+		metadataQuery.SetField(repoMetadataField(name))
+		musts = append(musts, metadataQuery)
+	}
 
-	result, err := repoIndexer.Search(searchRequest)
+	includes, excludes := pathPatternsQueries(opts.PathPatterns)
+	if len(includes) > 0 {
+		musts = append(musts, bleve.NewDisjunctionQuery(includes...))
+	}
+
+	if len(excludes) > 0 {
+		return query.NewBooleanQuery(musts, nil, excludes)
+	} else if len(musts) > 1 {
+		return bleve.NewConjunctionQuery(musts...)
+	}
+	return keywordQuery
+}
+
+// searchRepoIndex performs the search request, stopping it after the timeout,
+// or setting.Indexer.SearchTimeout if not positive
+func searchRepoIndex(ctx context.Context, index bleve.Index, searchRequest *bleve.SearchRequest, timeout time.Duration) (*bleve.SearchResult, error) {
+	if timeout <= 0 {
+		timeout = setting.Indexer.SearchTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return index.SearchInContext(ctx, searchRequest)
+}
+
+// SearchRepoByKeyword searches for files in the specified repo.
+// Returns the matching file-paths, and whether the search timed out. A search
+// which timed out is not an error, but has no results since the index does
+// not return partial results. The search is stopped if ctx is canceled.
+func SearchRepoByKeyword(ctx context.Context, opts *RepoSearchOptions) (int64, []*RepoSearchResult, bool, error) {
+	index, filenameOfID, err := repoSearchIndex(opts)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, false, err
+	}
+
+	page := opts.Page
+	if page <= 0 {
+		page = 1
+	}
+	from := (page - 1) * opts.PageSize
+	searchRequest := bleve.NewSearchRequestOptions(repoSearchQuery(opts), opts.PageSize, from, false)
+	searchRequest.Fields = []string{"Content", "RepoID", "Size", "LineCount",
+		"LastCommitSha", "LastCommitAuthorName", "LastCommitAuthorEmail", "LastCommitUnix"}
+	searchRequest.IncludeLocations = true
+
+	result, err := searchRepoIndex(ctx, index, searchRequest, opts.Timeout)
+	if err == context.DeadlineExceeded {
+		return 0, nil, true, nil
+	} else if err != nil {
+		return 0, nil, false, err
 	}
 
 	searchResults := make([]*RepoSearchResult, len(result.Hits))
 	for i, hit := range result.Hits {
+		matches := contentMatches(hit.Locations["Content"])
 		var startIndex, endIndex int = -1, -1
-		for _, locations := range hit.Locations["Content"] {
-			location := locations[0]
-			locationStart := int(location.Start)
-			locationEnd := int(location.End)
-			if startIndex < 0 || locationStart < startIndex {
-				startIndex = locationStart
-			}
-			if endIndex < 0 || locationEnd > endIndex {
-				endIndex = locationEnd
-			}
+		if len(matches) > 0 {
+			startIndex, endIndex = matches[0].StartIndex, matches[0].EndIndex
 		}
 		searchResults[i] = &RepoSearchResult{
 			RepoID:     int64(hit.Fields["RepoID"].(float64)),
 			StartIndex: startIndex,
 			EndIndex:   endIndex,
-			Filename:   filenameOfIndexerID(hit.ID),
+			Matches:    matches,
+			Filename:   filenameOfID(hit.ID),
 			Content:    hit.Fields["Content"].(string),
 		}
+		// documents indexed by older versions may not have these fields
+		if size, ok := hit.Fields["Size"].(float64); ok {
+			searchResults[i].Size = int64(size)
+		}
+		if lineCount, ok := hit.Fields["LineCount"].(float64); ok {
+			searchResults[i].LineCount = int(lineCount)
+		}
+		if sha, ok := hit.Fields["LastCommitSha"].(string); ok {
+			searchResults[i].LastCommitSha = sha
+		}
+		if name, ok := hit.Fields["LastCommitAuthorName"].(string); ok {
+			searchResults[i].LastCommitAuthorName = name
+		}
+		if email, ok := hit.Fields["LastCommitAuthorEmail"].(string); ok {
+			searchResults[i].LastCommitAuthorEmail = email
+		}
+		if unix, ok := hit.Fields["LastCommitUnix"].(float64); ok {
+			searchResults[i].LastCommitUnix = util.TimeStamp(unix)
+		}
 	}
-	return int64(result.Total), searchResults, nil
+	return int64(result.Total), searchResults, false, nil
 }
@@ -0,0 +1,112 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"strings"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/blevesearch/bleve"
+	"github.com/ethantkoenig/rupture"
+)
+
+// repoHistoryIndexer (thread-safe) index for every indexed version of
+// repository contents, nil unless the history indexer is enabled. Versions
+// are never removed from it, except when their repository is deleted.
+var repoHistoryIndexer bleve.Index
+
+// initRepoHistoryIndexer initialize the repo history indexer, and returns
+// true if it was created
+func initRepoHistoryIndexer() bool {
+	var err error
+	repoHistoryIndexer, err = openIndexer(setting.Indexer.RepoHistoryPath, repoIndexerLatestVersion)
+	if err != nil {
+		log.Fatal(4, "InitRepoHistoryIndexer: %v", err)
+	}
+	if repoHistoryIndexer != nil {
+		if err = checkRepoIndexerAnalyzer(repoHistoryIndexer, setting.Indexer.RepoHistoryPath); err != nil {
+			log.Fatal(4, "InitRepoHistoryIndexer: %v", err)
+		}
+		return false
+	}
+	if repoHistoryIndexer, err = createRepoIndexer(setting.Indexer.RepoHistoryPath); err != nil {
+		log.Fatal(4, "CreateRepoHistoryIndexer: %v", err)
+	}
+	return true
+}
+
+// historyIndexerID the ID in the history indexer of a version of a file,
+// identified by its blob
+func historyIndexerID(repoID int64, blobSha, filename string) string {
+	return indexerID(repoID) + "_" + blobSha + "_" + filename
+}
+
+func filenameOfHistoryIndexerID(indexerID string) string {
+	parts := strings.SplitN(indexerID, "_", 3)
+	if len(parts) != 3 {
+		log.Error(4, "Unexpected ID in repo history indexer: %s", indexerID)
+		return parts[len(parts)-1]
+	}
+	return parts[2]
+}
+
+// repoIndexerBatch a batch of the repo indexer which also adds the indexed
+// files to the history indexer. Deletions only apply to the repo indexer,
+// since past versions of files remain searchable.
+type repoIndexerBatch struct {
+	current rupture.FlushingBatch
+	history rupture.FlushingBatch
+}
+
+func (b *repoIndexerBatch) Index(id string, data interface{}) error {
+	if err := b.current.Index(id, data); err != nil {
+		return err
+	}
+	indexerData, ok := data.(*RepoIndexerData)
+	if !ok || len(indexerData.BlobSha) == 0 {
+		return nil
+	}
+	return b.history.Index(historyIndexerID(indexerData.RepoID, indexerData.BlobSha, indexerData.Filename), data)
+}
+
+func (b *repoIndexerBatch) Delete(id string) error {
+	return b.current.Delete(id)
+}
+
+func (b *repoIndexerBatch) Flush() error {
+	if err := b.current.Flush(); err != nil {
+		return err
+	}
+	return b.history.Flush()
+}
+
+// DeleteRepoFromHistoryIndexer delete all versions of a repo's files from the
+// history indexer, if it is enabled
+func DeleteRepoFromHistoryIndexer(repoID int64) error {
+	if repoHistoryIndexer == nil {
+		return nil
+	}
+	query := numericEqualityQuery(repoID, "RepoID")
+	searchRequest := bleve.NewSearchRequestOptions(query, 2147483647, 0, false)
+	result, err := repoHistoryIndexer.Search(searchRequest)
+	if err != nil {
+		return err
+	}
+	batch := newRepoFlushingBatch(repoHistoryIndexer)
+	for _, hit := range result.Hits {
+		if err = batch.Delete(hit.ID); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// IsRepoHistoryIndexerEnabled returns true if files can be searched in all
+// their indexed versions
+func IsRepoHistoryIndexerEnabled() bool {
+	return repoHistoryIndexer != nil
+}
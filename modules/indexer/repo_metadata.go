@@ -0,0 +1,117 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/blevesearch/bleve"
+)
+
+// RepoMetadataExtractor extracts the value of a metadata field from the
+// contents of a file, or returns "" if the file has none
+type RepoMetadataExtractor func(content []byte) string
+
+// repoMetadataExtractors the extractors of metadata fields, by the name of the
+// field listed in the [indexer] REPO_INDEXER_METADATA setting
+var repoMetadataExtractors = map[string]RepoMetadataExtractor{
+	"spdx": extractSPDXIdentifier,
+}
+
+// RegisterRepoMetadataExtractor registers the extractor of a metadata field,
+// which is indexed if its name is listed in the [indexer]
+// REPO_INDEXER_METADATA setting
+func RegisterRepoMetadataExtractor(name string, extractor RepoMetadataExtractor) {
+	repoMetadataExtractors[name] = extractor
+}
+
+// checkRepoMetadataExtractors checks that all metadata fields enabled by the
+// settings have an extractor
+func checkRepoMetadataExtractors() error {
+	for _, name := range setting.Indexer.RepoIndexerMetadata {
+		if _, ok := repoMetadataExtractors[name]; !ok {
+			return fmt.Errorf("unknown repo indexer metadata field: %s", name)
+		}
+	}
+	return nil
+}
+
+// ExtractRepoMetadata returns the values of the enabled metadata fields found
+// in the contents of a file, nil if there are none
+func ExtractRepoMetadata(content []byte) map[string]string {
+	var metadata map[string]string
+	for _, name := range setting.Indexer.RepoIndexerMetadata {
+		extractor, ok := repoMetadataExtractors[name]
+		if !ok {
+			continue
+		}
+		if value := extractor(content); len(value) > 0 {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// maxSPDXLines the number of lines at the start of a file searched for an
+// SPDX license identifier, which may follow e.g. a shebang line
+const maxSPDXLines = 5
+
+var spdxIdentifierPattern = regexp.MustCompile(`SPDX-License-Identifier:\s*(.*)`)
+
+// extractSPDXIdentifier returns the SPDX license identifier declared at the
+// start of a file
+func extractSPDXIdentifier(content []byte) string {
+	lines := bytes.SplitN(content, []byte{'\n'}, maxSPDXLines+1)
+	if len(lines) > maxSPDXLines {
+		lines = lines[:maxSPDXLines]
+	}
+	for _, line := range lines {
+		if match := spdxIdentifierPattern.FindSubmatch(line); match != nil {
+			// the identifier may be followed by the end of a comment
+			identifier := strings.TrimSpace(string(match[1]))
+			identifier = strings.TrimSuffix(strings.TrimSuffix(identifier, "*/"), "-->")
+			return strings.TrimSpace(identifier)
+		}
+	}
+	return ""
+}
+
+// repoMetadataField the indexed field of a metadata field
+func repoMetadataField(name string) string {
+	return "Metadata." + name
+}
+
+// CountRepoMetadata returns the number of files matching the search options
+// by value of the given metadata field, up to size values with the most files.
+func CountRepoMetadata(ctx context.Context, opts *RepoSearchOptions, name string, size int) (map[string]int, error) {
+	index, _, err := repoSearchIndex(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	searchRequest := bleve.NewSearchRequestOptions(repoSearchQuery(opts), 0, 0, false)
+	searchRequest.AddFacet(name, bleve.NewFacetRequest(repoMetadataField(name), size))
+	result, err := searchRepoIndex(ctx, index, searchRequest, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	if facet, ok := result.Facets[name]; ok {
+		for _, term := range facet.Terms {
+			counts[term.Term] = term.Count
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractSPDXIdentifier(t *testing.T) {
+	for content, expected := range map[string]string{
+		"// SPDX-License-Identifier: MIT\npackage main\n":             "MIT",
+		"#!/bin/sh\n# SPDX-License-Identifier: GPL-2.0-only OR MIT\n": "GPL-2.0-only OR MIT",
+		"/* SPDX-License-Identifier: Apache-2.0 */\nint main() {}\n":  "Apache-2.0",
+		"<!-- SPDX-License-Identifier: CC-BY-4.0 -->\n":               "CC-BY-4.0",
+		"package main\n": "",
+		"1\n2\n3\n4\n5\n// SPDX-License-Identifier: MIT\n":                 "",
+		"// SPDX-License-Identifier: MIT":                                  "MIT",
+		"line\n// SPDX-License-Identifier: BSD-3-Clause\nmore\nlines\n":    "BSD-3-Clause",
+		"// SPDX-License-Identifier:    LGPL-2.1-or-later   \npackage a\n": "LGPL-2.1-or-later",
+	} {
+		assert.Equal(t, expected, extractSPDXIdentifier([]byte(content)), "content %q", content)
+	}
+}
+
+func TestExtractRepoMetadata(t *testing.T) {
+	oldMetadata := setting.Indexer.RepoIndexerMetadata
+	defer func() {
+		setting.Indexer.RepoIndexerMetadata = oldMetadata
+	}()
+
+	content := []byte("// SPDX-License-Identifier: MIT\npackage main\n")
+	setting.Indexer.RepoIndexerMetadata = nil
+	assert.Nil(t, ExtractRepoMetadata(content))
+
+	setting.Indexer.RepoIndexerMetadata = []string{"spdx"}
+	assert.Equal(t, map[string]string{"spdx": "MIT"}, ExtractRepoMetadata(content))
+	assert.Nil(t, ExtractRepoMetadata([]byte("package main\n")))
+
+	setting.Indexer.RepoIndexerMetadata = []string{"spdx", "unknown"}
+	assert.Error(t, checkRepoMetadataExtractors())
+}
+
+func TestSearchRepoByKeyword_Metadata(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+
+	batch := RepoIndexerBatch()
+	for _, data := range []*RepoIndexerData{
+		{RepoID: 1, Filename: "a.go", Content: "func match() {}", Metadata: map[string]string{"spdx": "MIT"}},
+		{RepoID: 1, Filename: "b.go", Content: "func match() {}", Metadata: map[string]string{"spdx": "GPL-2.0-only OR MIT"}},
+		{RepoID: 1, Filename: "c.go", Content: "func match() {}", Metadata: map[string]string{"spdx": "MIT"}},
+		{RepoID: 1, Filename: "d.go", Content: "func match() {}"},
+	} {
+		assert.NoError(t, RepoIndexerUpdate{
+			Filepath: data.Filename,
+			Op:       RepoIndexerOpUpdate,
+			Data:     data,
+		}.AddToFlushingBatch(batch))
+	}
+	assert.NoError(t, batch.Flush())
+
+	assert.ElementsMatch(t, []string{"a.go", "c.go"},
+		searchTestFiles(t, &RepoSearchOptions{Keyword: "match", Metadata: map[string]string{"spdx": "MIT"}}))
+	assert.Equal(t, []string{"b.go"},
+		searchTestFiles(t, &RepoSearchOptions{Keyword: "match", Metadata: map[string]string{"spdx": "GPL-2.0-only OR MIT"}}))
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "match", Metadata: map[string]string{"spdx": "mit"}}), 0)
+
+	counts, err := CountRepoMetadata(context.Background(), &RepoSearchOptions{Keyword: "match"}, "spdx", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"MIT": 2, "GPL-2.0-only OR MIT": 1}, counts)
+}
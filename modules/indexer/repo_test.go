@@ -0,0 +1,434 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/blevesearch/bleve/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, testCase := range []struct {
+		glob     string
+		matches  []string
+		excludes []string
+	}{
+		{"*.go", []string{"main.go"}, []string{"cmd/main.go", "main.go.orig"}},
+		{"src/**/*.go", []string{"src/a.go", "src/b/c/d.go"}, []string{"vendor/src/a.go", "src/a.js"}},
+		{"vendor/**", []string{"vendor/a", "vendor/b/c.go"}, []string{"src/vendor/a"}},
+		{"docs/?.md", []string{"docs/a.md"}, []string{"docs/ab.md", "docs/a/b.md"}},
+		{"a+b(c).txt", []string{"a+b(c).txt"}, []string{"aab(c).txt"}},
+	} {
+		re := regexp.MustCompile("^" + globToRegexp(testCase.glob) + "$")
+		for _, path := range testCase.matches {
+			assert.True(t, re.MatchString(path), "%s should match %s", testCase.glob, path)
+		}
+		for _, path := range testCase.excludes {
+			assert.False(t, re.MatchString(path), "%s should not match %s", testCase.glob, path)
+		}
+	}
+}
+
+func TestContentMatches(t *testing.T) {
+	// "foo bar baz foo bar", searching for the phrase "foo bar"
+	matches := contentMatches(search.TermLocationMap{
+		"foo": {
+			{Pos: 1, Start: 0, End: 3},
+			{Pos: 4, Start: 12, End: 15},
+		},
+		"bar": {
+			{Pos: 2, Start: 4, End: 7},
+			{Pos: 5, Start: 16, End: 19},
+		},
+	})
+	assert.Equal(t, []RepoSearchMatch{
+		{StartIndex: 0, EndIndex: 7},
+		{StartIndex: 12, EndIndex: 19},
+	}, matches)
+
+	assert.Len(t, contentMatches(nil), 0)
+}
+
+// initTestRepoIndexer initializes the repo indexer, and optionally the
+// history indexer, in a temporary directory, returning a function to close
+// and remove them
+func initTestRepoIndexer(t *testing.T, analyzer string, history bool) func() {
+	dir, err := ioutil.TempDir("", "repo-indexer")
+	assert.NoError(t, err)
+
+	oldIndexer := setting.Indexer
+	setting.Indexer.RepoPath = filepath.Join(dir, "repos.bleve")
+	setting.Indexer.RepoHistoryIndexerEnabled = history
+	setting.Indexer.RepoHistoryPath = filepath.Join(dir, "repos_history.bleve")
+	setting.Indexer.RepoIndexerAnalyzer = analyzer
+	InitRepoIndexer(func() error { return nil }, func() error { return nil })
+	return func() {
+		assert.NoError(t, CloseRepoIndexer())
+		setting.Indexer = oldIndexer
+		os.RemoveAll(dir)
+	}
+}
+
+func indexTestFile(t *testing.T, filename, content string) {
+	batch := RepoIndexerBatch()
+	assert.NoError(t, RepoIndexerUpdate{
+		Filepath: filename,
+		Op:       RepoIndexerOpUpdate,
+		Data: &RepoIndexerData{
+			RepoID:   1,
+			Filename: filename,
+			Content:  content,
+			BlobSha:  base.EncodeSha1(content),
+		},
+	}.AddToFlushingBatch(batch))
+	assert.NoError(t, batch.Flush())
+}
+
+func searchTestFiles(t *testing.T, opts *RepoSearchOptions) []string {
+	opts.RepoIDs = []int64{1}
+	opts.Page, opts.PageSize = 1, 10
+	_, results, _, err := SearchRepoByKeyword(context.Background(), opts)
+	assert.NoError(t, err)
+	filenames := make([]string, len(results))
+	for i, result := range results {
+		filenames[i] = result.Filename
+	}
+	return filenames
+}
+
+func TestSearchRepoByKeyword_Timeout(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+	indexTestFile(t, "README.md", "hello world")
+
+	opts := &RepoSearchOptions{
+		RepoIDs:  []int64{1},
+		Keyword:  "hello",
+		Timeout:  time.Minute,
+		Page:     1,
+		PageSize: 10,
+	}
+	total, results, timedOut, err := SearchRepoByKeyword(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.False(t, timedOut)
+	assert.EqualValues(t, 1, total)
+	assert.Len(t, results, 1)
+
+	// the deadline has passed before the search starts
+	opts.Timeout = time.Nanosecond
+	total, results, timedOut, err = SearchRepoByKeyword(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.True(t, timedOut)
+	assert.EqualValues(t, 0, total)
+	assert.Len(t, results, 0)
+
+	// the request was canceled before the search starts
+	opts.Timeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, timedOut, err = SearchRepoByKeyword(ctx, opts)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, timedOut)
+}
+
+func TestRepoIndexerAnalyzer(t *testing.T) {
+	for _, testCase := range []struct {
+		analyzer string
+		keyword  string
+		found    bool
+	}{
+		{"default", "max_file_size", true},
+		{"default", "file size", true},
+		{"default", "indexed files", true},
+		{"default", "indexing file", false},
+		{"english", "max_file_size", true},
+		{"english", "indexed files", true},
+		{"english", "indexing file", true},
+	} {
+		cleanup := initTestRepoIndexer(t, testCase.analyzer, false)
+		indexTestFile(t, "main.go", "// maxFileSize of indexed files\nconst max_file_size = 1")
+
+		total, _, _, err := SearchRepoByKeyword(context.Background(), &RepoSearchOptions{
+			RepoIDs:  []int64{1},
+			Keyword:  testCase.keyword,
+			Page:     1,
+			PageSize: 10,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.found, total == 1, "%s analyzer, %q", testCase.analyzer, testCase.keyword)
+
+		assert.NoError(t, checkRepoIndexerAnalyzer(repoIndexer, setting.Indexer.RepoPath))
+		setting.Indexer.RepoIndexerAnalyzer = "other"
+		assert.Error(t, checkRepoIndexerAnalyzer(repoIndexer, setting.Indexer.RepoPath))
+		cleanup()
+	}
+}
+
+func TestSearchRepoByKeyword_AllHistory(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", true)()
+	assert.True(t, IsRepoHistoryIndexerEnabled())
+
+	indexTestFile(t, "main.go", "func oldName() {}")
+	indexTestFile(t, "main.go", "func newName() {}")
+
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "oldName"}), 0)
+	assert.Equal(t, []string{"main.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "oldName", AllHistory: true}))
+	assert.Equal(t, []string{"main.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "newName", AllHistory: true}))
+
+	// removed files remain searchable in history
+	batch := RepoIndexerBatch()
+	assert.NoError(t, RepoIndexerUpdate{
+		Filepath: "main.go",
+		Op:       RepoIndexerOpDelete,
+		Data:     &RepoIndexerData{RepoID: 1},
+	}.AddToFlushingBatch(batch))
+	assert.NoError(t, batch.Flush())
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "newName"}), 0)
+	assert.Equal(t, []string{"main.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "newName", AllHistory: true}))
+
+	assert.NoError(t, DeleteRepoFromHistoryIndexer(1))
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "newName", AllHistory: true}), 0)
+}
+
+func TestRepoIndexerAnalyzer_CJK(t *testing.T) {
+	for _, testCase := range []struct {
+		analyzer string
+		keyword  string
+		found    bool
+	}{
+		{"default", "検索", true},
+		{"default", "エン", false},
+		{"default", "검색", false},
+		{"cjk", "検索", true},
+		{"cjk", "検索エンジン", true},
+		{"cjk", "エン", true},
+		{"cjk", "검색", true},
+		{"cjk", "代码片段", true},
+		{"cjk", "代码段", false},
+		{"cjk", "file size", true},
+	} {
+		cleanup := initTestRepoIndexer(t, testCase.analyzer, false)
+		indexTestFile(t, "main.go", "// 全文検索エンジンの設定\n// 검색엔진을 설정\n// 搜索代码片段\nconst maxFileSize = 1")
+
+		total, _, _, err := SearchRepoByKeyword(context.Background(), &RepoSearchOptions{
+			RepoIDs:  []int64{1},
+			Keyword:  testCase.keyword,
+			Page:     1,
+			PageSize: 10,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.found, total == 1, "%s analyzer, %q", testCase.analyzer, testCase.keyword)
+		cleanup()
+	}
+}
+
+func TestInitRepoIndexer_PopulateHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo-indexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldIndexer := setting.Indexer
+	defer func() { setting.Indexer = oldIndexer }()
+	setting.Indexer.RepoPath = filepath.Join(dir, "repos.bleve")
+	setting.Indexer.RepoHistoryPath = filepath.Join(dir, "repos_history.bleve")
+	setting.Indexer.RepoIndexerAnalyzer = "default"
+
+	var populated, historyPopulated int
+	populate := func() error {
+		populated++
+		return nil
+	}
+	populateHistory := func() error {
+		historyPopulated++
+		return nil
+	}
+	for _, history := range []bool{false, true, true} {
+		setting.Indexer.RepoHistoryIndexerEnabled = history
+		InitRepoIndexer(populate, populateHistory)
+		assert.NoError(t, CloseRepoIndexer())
+	}
+	// the history indexer is populated once, when it is created after the
+	// repo indexer
+	assert.Equal(t, 1, populated)
+	assert.Equal(t, 1, historyPopulated)
+}
+
+func TestSearchRepoByKeyword_Fuzziness(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+
+	indexTestFile(t, "a.go", "if err != nil { return err }")
+	indexTestFile(t, "b.go", "values are sorted")
+
+	assert.Equal(t, []string{"a.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "err"}))
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "eror"}), 0)
+	assert.Equal(t, []string{"a.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "eror", Fuzziness: 1}))
+	assert.ElementsMatch(t, []string{"a.go", "b.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "err", Fuzziness: 2}))
+	// all words must match, in any order
+	assert.Equal(t, []string{"b.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "sorted value", Fuzziness: 1}))
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "sorted value"}), 0)
+}
+
+func TestSearchRepoByKeyword_FuzzinessAuto(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+
+	indexTestFile(t, "a.go", "if err != nil { return err }")
+	indexTestFile(t, "b.go", "values are sorted")
+
+	// words of up to 2 characters match exactly, 1 edit up to 5 characters
+	// and 2 edits above
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "iff", Fuzziness: FuzzinessAuto}), 1)
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "of", Fuzziness: FuzzinessAuto}), 0)
+	assert.Equal(t, []string{"a.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "eror", Fuzziness: FuzzinessAuto}))
+	assert.Len(t, searchTestFiles(t, &RepoSearchOptions{Keyword: "erorr", Fuzziness: FuzzinessAuto}), 0)
+	assert.Equal(t, []string{"b.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "sortdd valus", Fuzziness: FuzzinessAuto}))
+	assert.Equal(t, []string{"a.go"}, searchTestFiles(t, &RepoSearchOptions{Keyword: "retrun", Fuzziness: FuzzinessAuto}))
+}
+
+func TestParseFuzziness(t *testing.T) {
+	for s, expected := range map[string]int{
+		"":     0,
+		"0":    0,
+		"2":    2,
+		"AUTO": FuzzinessAuto,
+		"auto": FuzzinessAuto,
+	} {
+		fuzziness, err := ParseFuzziness(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, fuzziness)
+	}
+
+	for _, s := range []string{"-1", "x", "1.5"} {
+		_, err := ParseFuzziness(s)
+		assert.Error(t, err)
+	}
+}
+
+func TestIndexedRepoIDs(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+
+	repoIDs, err := IndexedRepoIDs()
+	assert.NoError(t, err)
+	assert.Len(t, repoIDs, 0)
+
+	batch := RepoIndexerBatch()
+	for _, data := range []*RepoIndexerData{
+		{RepoID: 1, Filename: "a.go", Content: "a"},
+		{RepoID: 1, Filename: "b.go", Content: "b"},
+		{RepoID: 3, Filename: "a.go", Content: "a"},
+		{RepoID: 1000, Filename: "a.go", Content: "a"},
+	} {
+		assert.NoError(t, RepoIndexerUpdate{
+			Filepath: data.Filename,
+			Op:       RepoIndexerOpUpdate,
+			Data:     data,
+		}.AddToFlushingBatch(batch))
+	}
+	assert.NoError(t, batch.Flush())
+
+	repoIDs, err = IndexedRepoIDs()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{1, 3, 1000}, repoIDs)
+
+	assert.NoError(t, DeleteRepoFromIndexer(3))
+	repoIDs, err = IndexedRepoIDs()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{1, 1000}, repoIDs)
+}
+
+func TestRepoFlushingBatch(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+	setting.Indexer.RepoIndexerBatchSize = 3
+	setting.Indexer.RepoIndexerBatchBytes = 10
+
+	docCount := func() uint64 {
+		count, err := repoIndexer.DocCount()
+		assert.NoError(t, err)
+		return count
+	}
+	indexData := func(batch *repoFlushingBatch, filename, content string) {
+		assert.NoError(t, batch.Index(filenameIndexerID(1, filename), &RepoIndexerData{
+			RepoID:   1,
+			Filename: filename,
+			Content:  content,
+		}))
+	}
+
+	// flushed once the contents reach the maximum size
+	batch := newRepoFlushingBatch(repoIndexer)
+	indexData(batch, "a.go", "1234")
+	assert.EqualValues(t, 0, docCount())
+	indexData(batch, "b.go", "12345678")
+	assert.EqualValues(t, 2, docCount())
+
+	// flushed once it has the maximum number of operations
+	indexData(batch, "c.go", "1")
+	indexData(batch, "d.go", "1")
+	assert.EqualValues(t, 2, docCount())
+	assert.NoError(t, batch.Delete(filenameIndexerID(1, "a.go")))
+	assert.EqualValues(t, 3, docCount())
+}
+
+func TestSearchRepoByKeyword_AllHistoryDisabled(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+	assert.False(t, IsRepoHistoryIndexerEnabled())
+
+	_, _, _, err := SearchRepoByKeyword(context.Background(), &RepoSearchOptions{Keyword: "foo", AllHistory: true})
+	assert.Error(t, err)
+	assert.NoError(t, DeleteRepoFromHistoryIndexer(1))
+}
+
+func TestSearchRepoByKeyword_OwnerID(t *testing.T) {
+	defer initTestRepoIndexer(t, "default", false)()
+
+	batch := RepoIndexerBatch()
+	for _, data := range []*RepoIndexerData{
+		{RepoID: 1, OwnerID: 2, Filename: "a.go", Content: "func match() {}"},
+		{RepoID: 2, OwnerID: 2, Filename: "b.go", Content: "func match() {}"},
+		{RepoID: 3, OwnerID: 5, Filename: "c.go", Content: "func match() {}"},
+	} {
+		assert.NoError(t, RepoIndexerUpdate{
+			Filepath: data.Filename,
+			Op:       RepoIndexerOpUpdate,
+			Data:     data,
+		}.AddToFlushingBatch(batch))
+	}
+	assert.NoError(t, batch.Flush())
+
+	for _, testCase := range []struct {
+		repoIDs         []int64
+		ownerID         int64
+		expectedRepoIDs []int64
+	}{
+		{nil, 0, []int64{1, 2, 3}},
+		{nil, 2, []int64{1, 2}},
+		{nil, 5, []int64{3}},
+		{[]int64{1, 3}, 2, []int64{1}},
+		{nil, 7, []int64{}},
+	} {
+		_, results, _, err := SearchRepoByKeyword(context.Background(), &RepoSearchOptions{
+			RepoIDs:  testCase.repoIDs,
+			OwnerID:  testCase.ownerID,
+			Keyword:  "match",
+			Page:     1,
+			PageSize: 10,
+		})
+		assert.NoError(t, err)
+		repoIDs := make([]int64, len(results))
+		for i, result := range results {
+			repoIDs[i] = result.RepoID
+		}
+		assert.ElementsMatch(t, testCase.expectedRepoIDs, repoIDs, "repo IDs %v, owner %d", testCase.repoIDs, testCase.ownerID)
+	}
+}
@@ -37,6 +37,12 @@ type Collector struct {
 	Users         *prometheus.Desc
 	Watches       *prometheus.Desc
 	Webhooks      *prometheus.Desc
+
+	RepoIndexerUpdates        *prometheus.Desc
+	RepoIndexerUpdateErrors   *prometheus.Desc
+	RepoIndexerUpdateDuration *prometheus.Desc
+	RepoIndexerFiles          *prometheus.Desc
+	RepoIndexerBytes          *prometheus.Desc
 }
 
 // NewCollector returns a new Collector with all prometheus.Desc initialized
@@ -152,6 +158,31 @@ func NewCollector() Collector {
 			"Number of Webhooks",
 			nil, nil,
 		),
+		RepoIndexerUpdates: prometheus.NewDesc(
+			namespace+"repo_indexer_updates_total",
+			"Number of updates of repositories in the repo indexer",
+			nil, nil,
+		),
+		RepoIndexerUpdateErrors: prometheus.NewDesc(
+			namespace+"repo_indexer_update_errors_total",
+			"Number of failed updates of repositories in the repo indexer",
+			nil, nil,
+		),
+		RepoIndexerUpdateDuration: prometheus.NewDesc(
+			namespace+"repo_indexer_update_duration_seconds_total",
+			"Total duration of updates of repositories in the repo indexer",
+			nil, nil,
+		),
+		RepoIndexerFiles: prometheus.NewDesc(
+			namespace+"repo_indexer_files_total",
+			"Number of files indexed by the repo indexer",
+			nil, nil,
+		),
+		RepoIndexerBytes: prometheus.NewDesc(
+			namespace+"repo_indexer_bytes_total",
+			"Size in bytes of the files indexed by the repo indexer",
+			nil, nil,
+		),
 	}
 
 }
@@ -180,6 +211,11 @@ func (c Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.Users
 	ch <- c.Watches
 	ch <- c.Webhooks
+	ch <- c.RepoIndexerUpdates
+	ch <- c.RepoIndexerUpdateErrors
+	ch <- c.RepoIndexerUpdateDuration
+	ch <- c.RepoIndexerFiles
+	ch <- c.RepoIndexerBytes
 }
 
 // Collect returns the metrics with values
@@ -296,4 +332,31 @@ func (c Collector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue,
 		float64(stats.Counter.Webhook),
 	)
+
+	indexerStats := models.GetRepoIndexerStatistic()
+	ch <- prometheus.MustNewConstMetric(
+		c.RepoIndexerUpdates,
+		prometheus.CounterValue,
+		float64(indexerStats.Updates),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RepoIndexerUpdateErrors,
+		prometheus.CounterValue,
+		float64(indexerStats.UpdateErrors),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RepoIndexerUpdateDuration,
+		prometheus.CounterValue,
+		indexerStats.UpdateDuration.Seconds(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RepoIndexerFiles,
+		prometheus.CounterValue,
+		float64(indexerStats.Files),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RepoIndexerBytes,
+		prometheus.CounterValue,
+		float64(indexerStats.Bytes),
+	)
 }
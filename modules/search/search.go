@@ -6,12 +6,19 @@ package search
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html"
 	gotemplate "html/template"
+	"path"
+	"sort"
 	"strings"
 
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/base"
 	"code.gitea.io/gitea/modules/highlight"
 	"code.gitea.io/gitea/modules/indexer"
+	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/util"
 )
 
@@ -20,8 +27,111 @@ type Result struct {
 	RepoID         int64
 	Filename       string
 	HighlightClass string
+	Fragments      []*Fragment
+	// NumOmittedFragments the number of fragments left out because of the
+	// maximum number of fragments per result
+	NumOmittedFragments int
+	// MatchLineNumber the number of the first line with a match, 0 if none,
+	// to link to the match in the file
+	MatchLineNumber int
+	// AnchorID the HTML id of the result, unique among the results of a
+	// search and stable across searches
+	AnchorID  string
+	Size      int64
+	LineCount int
+	// IsHistorical whether the result is a version of the file found by
+	// searching all history, which may differ from its current version
+	IsHistorical bool
+
+	LastCommitSha         string
+	LastCommitAuthorName  string
+	LastCommitAuthorEmail string
+	LastCommitUnix        util.TimeStamp
+}
+
+// Fragment consecutive lines of a search result containing one or more
+// matches
+type Fragment struct {
 	LineNumbers    []int
 	FormattedLines gotemplate.HTML
+	// Lines the unformatted lines, for consumers other than the web UI
+	Lines []*Line
+}
+
+// Line a line of a fragment
+type Line struct {
+	Number  int
+	Content string
+	// Matches the ranges of the matches on the line, in order
+	Matches []LineMatch
+}
+
+// LineMatch the range of a match in the content of a line
+type LineMatch struct {
+	StartIndex int
+	EndIndex   int
+}
+
+// fragmentRange the indices of a fragment and the matches it contains
+type fragmentRange struct {
+	startIndex int
+	endIndex   int
+	matches    []indexer.RepoSearchMatch
+}
+
+// fragmentRanges returns the ranges of the fragments of a search result, in
+// order. Fragments which overlap or are adjacent are merged. A result
+// without matches has a single fragment at the start of the file.
+func fragmentRanges(result *indexer.RepoSearchResult, expandToBlock bool) []*fragmentRange {
+	if len(result.Matches) == 0 {
+		startIndex, endIndex := indices(result.Content, 0, 0)
+		return []*fragmentRange{{
+			startIndex: startIndex,
+			endIndex:   endIndex,
+		}}
+	}
+
+	ranges := make([]*fragmentRange, 0, len(result.Matches))
+	for _, match := range result.Matches {
+		// guard against positions past the end of the stored content
+		match.StartIndex = util.Min(match.StartIndex, len(result.Content))
+		match.EndIndex = util.Min(util.Max(match.EndIndex, match.StartIndex), len(result.Content))
+
+		var startIndex, endIndex int
+		var ok bool
+		if expandToBlock {
+			startIndex, endIndex, ok = blockIndices(result.Filename, result.Content, match.StartIndex, match.EndIndex)
+		}
+		if !ok {
+			startIndex, endIndex = indices(result.Content, match.StartIndex, match.EndIndex)
+		}
+		ranges = append(ranges, &fragmentRange{
+			startIndex: startIndex,
+			endIndex:   endIndex,
+			matches:    []indexer.RepoSearchMatch{match},
+		})
+	}
+	// blocks may start before the fragments of earlier matches
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].startIndex < ranges[j].startIndex
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.startIndex <= merged[n-1].endIndex+1 {
+			last := merged[n-1]
+			last.endIndex = util.Max(last.endIndex, r.endIndex)
+			last.matches = append(last.matches, r.matches...)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	for _, r := range merged {
+		sort.Slice(r.matches, func(i, j int) bool {
+			return r.matches[i].StartIndex < r.matches[j].StartIndex
+		})
+	}
+	return merged
 }
 
 func indices(content string, selectionStartIndex, selectionEndIndex int) (int, int) {
@@ -50,6 +160,174 @@ func indices(content string, selectionStartIndex, selectionEndIndex int) (int, i
 	return startIndex, endIndex
 }
 
+// maxBlockLines the maximum number of lines of a snippet expanded to its
+// enclosing block; larger blocks fall back to the fixed window
+const maxBlockLines = 50
+
+type blockStyle int
+
+const (
+	blockStyleNone blockStyle = iota
+	// blocks are delimited by braces
+	blockStyleBraces
+	// blocks are opened by a line ending with ":" and delimited by indentation
+	blockStyleIndent
+)
+
+// blockStyles the block style of supported languages, by file extension
+var blockStyles = map[string]blockStyle{
+	".c":     blockStyleBraces,
+	".cc":    blockStyleBraces,
+	".cpp":   blockStyleBraces,
+	".cs":    blockStyleBraces,
+	".go":    blockStyleBraces,
+	".h":     blockStyleBraces,
+	".hpp":   blockStyleBraces,
+	".java":  blockStyleBraces,
+	".js":    blockStyleBraces,
+	".kt":    blockStyleBraces,
+	".php":   blockStyleBraces,
+	".rs":    blockStyleBraces,
+	".scala": blockStyleBraces,
+	".swift": blockStyleBraces,
+	".ts":    blockStyleBraces,
+	".py":    blockStyleIndent,
+}
+
+// blockIndices returns the indices of the lines of the block enclosing the
+// selection. Nested blocks are expanded to their outer block, e.g. an if
+// statement to its function, as long as the result does not exceed
+// maxBlockLines. Returns false if the language of the file is not supported
+// or no suitable block is found.
+func blockIndices(filename, content string, selectionStartIndex, selectionEndIndex int) (int, int, bool) {
+	var enclosingBlock func(content string, startIndex, endIndex int) (int, int, bool)
+	switch blockStyles[strings.ToLower(path.Ext(filename))] {
+	case blockStyleBraces:
+		enclosingBlock = enclosingBraceBlock
+	case blockStyleIndent:
+		enclosingBlock = enclosingIndentBlock
+	default:
+		return 0, 0, false
+	}
+
+	startIndex, endIndex, ok := enclosingBlock(content, selectionStartIndex, selectionEndIndex)
+	if !ok || numLines(content, startIndex, endIndex) > maxBlockLines {
+		return 0, 0, false
+	}
+	// top-level blocks are not indented
+	for isIndented(content[startIndex:]) {
+		outerStartIndex, outerEndIndex, ok := enclosingBlock(content, startIndex, endIndex)
+		if !ok || numLines(content, outerStartIndex, outerEndIndex) > maxBlockLines {
+			break
+		}
+		startIndex, endIndex = outerStartIndex, outerEndIndex
+	}
+	return startIndex, endIndex, true
+}
+
+// enclosingBraceBlock returns the indices of the lines from the unmatched "{"
+// before startIndex to its matching "}" after endIndex. Braces in strings
+// and comments are not taken into account.
+func enclosingBraceBlock(content string, startIndex, endIndex int) (int, int, bool) {
+	openIndex := -1
+	for i, depth := startIndex-1, 0; i >= 0; i-- {
+		if content[i] == '}' {
+			depth++
+		} else if content[i] == '{' {
+			if depth == 0 {
+				openIndex = i
+				break
+			}
+			depth--
+		}
+	}
+	if openIndex < 0 {
+		return 0, 0, false
+	}
+
+	closeIndex := -1
+	for i, depth := endIndex, 0; i < len(content); i++ {
+		if content[i] == '{' {
+			depth++
+		} else if content[i] == '}' {
+			if depth == 0 {
+				closeIndex = i
+				break
+			}
+			depth--
+		}
+	}
+	if closeIndex < 0 {
+		return 0, 0, false
+	}
+	return lineStartIndex(content, openIndex), lineEndIndex(content, closeIndex), true
+}
+
+// enclosingIndentBlock returns the indices of the lines from the closest less
+// indented line ending with ":" before startIndex to the last line indented
+// more than it.
+func enclosingIndentBlock(content string, startIndex, endIndex int) (int, int, bool) {
+	lineStart := lineStartIndex(content, startIndex)
+	indent := indentation(content[lineStart:])
+
+	openIndex := -1
+	for lineStart > 0 {
+		lineStart = lineStartIndex(content, lineStart-1)
+		line := strings.TrimSpace(content[lineStart:lineEndIndex(content, lineStart)])
+		if len(line) == 0 || indentation(content[lineStart:]) >= indent {
+			continue
+		} else if !strings.HasSuffix(line, ":") {
+			return 0, 0, false
+		}
+		openIndex = lineStart
+		break
+	}
+	if openIndex < 0 {
+		return 0, 0, false
+	}
+
+	openIndent := indentation(content[openIndex:])
+	blockEndIndex := lineEndIndex(content, endIndex)
+	for i := blockEndIndex; i < len(content); {
+		lineEnd := lineEndIndex(content, i+1)
+		line := content[i+1 : lineEnd]
+		if len(strings.TrimSpace(line)) > 0 {
+			if indentation(line) <= openIndent {
+				break
+			}
+			blockEndIndex = lineEnd
+		}
+		i = lineEnd
+	}
+	return openIndex, blockEndIndex, true
+}
+
+// lineStartIndex returns the index of the start of the line containing index
+func lineStartIndex(content string, index int) int {
+	return strings.LastIndexByte(content[:index], '\n') + 1
+}
+
+// lineEndIndex returns the index of the newline ending the line containing
+// index, or the length of the content for the last line
+func lineEndIndex(content string, index int) int {
+	if i := strings.IndexByte(content[index:], '\n'); i >= 0 {
+		return index + i
+	}
+	return len(content)
+}
+
+func numLines(content string, startIndex, endIndex int) int {
+	return strings.Count(content[startIndex:endIndex], "\n") + 1
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func isIndented(line string) bool {
+	return len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+}
+
 func writeStrings(buf *bytes.Buffer, strs ...string) error {
 	for _, s := range strs {
 		_, err := buf.WriteString(s)
@@ -60,72 +338,256 @@ func writeStrings(buf *bytes.Buffer, strs ...string) error {
 	return nil
 }
 
-func searchResult(result *indexer.RepoSearchResult, startIndex, endIndex int) (*Result, error) {
-	startLineNum := 1 + strings.Count(result.Content[:startIndex], "\n")
+// fragmentLines returns the lines of a fragment with the ranges of the
+// matches they contain
+func fragmentLines(content string, r *fragmentRange) []*Line {
+	startLineNum := 1 + strings.Count(content[:r.startIndex], "\n")
 
-	var formattedLinesBuffer bytes.Buffer
+	contentLines := strings.Split(content[r.startIndex:r.endIndex], "\n")
+	lines := make([]*Line, len(contentLines))
+	index := r.startIndex
+	for i, lineContent := range contentLines {
+		line := &Line{
+			Number:  startLineNum + i,
+			Content: lineContent,
+		}
+		end := 0
+		for _, match := range r.matches {
+			if match.EndIndex <= index ||
+				index+len(lineContent) <= match.StartIndex ||
+				match.EndIndex <= match.StartIndex {
+				continue
+			}
+			startIndex := util.Max(match.StartIndex-index, end)
+			endIndex := util.Min(match.EndIndex-index, len(lineContent))
+			if endIndex <= startIndex {
+				continue
+			}
+			line.Matches = append(line.Matches, LineMatch{
+				StartIndex: startIndex,
+				EndIndex:   endIndex,
+			})
+			end = endIndex
+		}
+		lines[i] = line
+		index += len(lineContent) + 1
+	}
+	return lines
+}
 
-	contentLines := strings.SplitAfter(result.Content[startIndex:endIndex], "\n")
-	lineNumbers := make([]int, len(contentLines))
-	index := startIndex
-	for i, line := range contentLines {
-		var err error
-		if index < result.EndIndex &&
-			result.StartIndex < index+len(line) &&
-			result.StartIndex < result.EndIndex {
-			openActiveIndex := util.Max(result.StartIndex-index, 0)
-			closeActiveIndex := util.Min(result.EndIndex-index, len(line))
-			err = writeStrings(&formattedLinesBuffer,
-				`<li>`,
-				html.EscapeString(line[:openActiveIndex]),
+// formatLines formats the lines of a fragment as HTML list items, with their
+// matches highlighted
+func formatLines(lines []*Line) (gotemplate.HTML, error) {
+	var formattedLinesBuffer bytes.Buffer
+	for i, line := range lines {
+		if _, err := formattedLinesBuffer.WriteString(`<li>`); err != nil {
+			return "", err
+		}
+		written := 0
+		for _, match := range line.Matches {
+			if err := writeStrings(&formattedLinesBuffer,
+				html.EscapeString(line.Content[written:match.StartIndex]),
 				`<span class='active'>`,
-				html.EscapeString(line[openActiveIndex:closeActiveIndex]),
+				html.EscapeString(line.Content[match.StartIndex:match.EndIndex]),
 				`</span>`,
-				html.EscapeString(line[closeActiveIndex:]),
-				`</li>`,
-			)
-		} else {
-			err = writeStrings(&formattedLinesBuffer,
-				`<li>`,
-				html.EscapeString(line),
-				`</li>`,
-			)
+			); err != nil {
+				return "", err
+			}
+			written = match.EndIndex
 		}
-		if err != nil {
+		if err := writeStrings(&formattedLinesBuffer, html.EscapeString(line.Content[written:])); err != nil {
+			return "", err
+		}
+		if i < len(lines)-1 {
+			if err := formattedLinesBuffer.WriteByte('\n'); err != nil {
+				return "", err
+			}
+		}
+		if _, err := formattedLinesBuffer.WriteString(`</li>`); err != nil {
+			return "", err
+		}
+	}
+	return gotemplate.HTML(formattedLinesBuffer.String()), nil
+}
+
+func formatFragment(content string, r *fragmentRange) (*Fragment, error) {
+	lines := fragmentLines(content, r)
+	formattedLines, err := formatLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	lineNumbers := make([]int, len(lines))
+	for i, line := range lines {
+		lineNumbers[i] = line.Number
+	}
+	return &Fragment{
+		LineNumbers:    lineNumbers,
+		FormattedLines: formattedLines,
+		Lines:          lines,
+	}, nil
+}
+
+func searchResult(result *indexer.RepoSearchResult, maxFragments int, expandToBlock, isHistorical bool) (*Result, error) {
+	if maxFragments <= 0 {
+		maxFragments = indexer.DefaultMaxFragments
+	}
+
+	ranges := fragmentRanges(result, expandToBlock)
+	var numOmittedFragments int
+	if len(ranges) > maxFragments {
+		numOmittedFragments = len(ranges) - maxFragments
+		ranges = ranges[:maxFragments]
+	}
+
+	fragments := make([]*Fragment, len(ranges))
+	var matchLineNumber int
+	for i, r := range ranges {
+		var err error
+		if fragments[i], err = formatFragment(result.Content, r); err != nil {
 			return nil, err
 		}
+		for _, line := range fragments[i].Lines {
+			if matchLineNumber == 0 && len(line.Matches) > 0 {
+				matchLineNumber = line.Number
+			}
+		}
+	}
 
-		lineNumbers[i] = startLineNum + i
-		index += len(line)
+	anchorKey := fmt.Sprintf("%d/%s", result.RepoID, result.Filename)
+	if isHistorical {
+		// versions of a file found by searching all history
+		anchorKey += "@" + result.LastCommitSha
 	}
 	return &Result{
-		RepoID:         result.RepoID,
-		Filename:       result.Filename,
-		HighlightClass: highlight.FileNameToHighlightClass(result.Filename),
-		LineNumbers:    lineNumbers,
-		FormattedLines: gotemplate.HTML(formattedLinesBuffer.String()),
+		RepoID:              result.RepoID,
+		Filename:            result.Filename,
+		HighlightClass:      highlight.FileNameToHighlightClass(result.Filename),
+		Fragments:           fragments,
+		NumOmittedFragments: numOmittedFragments,
+		MatchLineNumber:     matchLineNumber,
+		AnchorID:            "result-" + base.EncodeSha1(anchorKey)[:12],
+		Size:                result.Size,
+		LineCount:           result.LineCount,
+		IsHistorical:        isHistorical,
+
+		LastCommitSha:         result.LastCommitSha,
+		LastCommitAuthorName:  result.LastCommitAuthorName,
+		LastCommitAuthorEmail: result.LastCommitAuthorEmail,
+		LastCommitUnix:        result.LastCommitUnix,
 	}, nil
 }
 
-// PerformSearch perform a search on a repository
-func PerformSearch(repoIDs []int64, keyword string, page, pageSize int) (int, []*Result, error) {
-	if len(keyword) == 0 {
-		return 0, nil, nil
+// Pagination the pagination of search results
+type Pagination struct {
+	Total      int
+	Page       int
+	PageSize   int
+	TotalPages int
+	// OutOfRange whether the requested page was after the last page, in which
+	// case the last page is returned instead
+	OutOfRange bool
+}
+
+// HasMore whether there are pages after the returned one
+func (p *Pagination) HasMore() bool {
+	return p.Page < p.TotalPages
+}
+
+// newPagination returns the pagination of total results for the requested
+// page, clamped to the range of available pages
+func newPagination(total, page, pageSize int) *Pagination {
+	p := &Pagination{
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}
+	if p.Page <= 0 {
+		p.Page = 1
+	}
+	if pageSize > 0 {
+		p.TotalPages = (total + pageSize - 1) / pageSize
+	}
+	if lastPage := util.Max(p.TotalPages, 1); p.Page > lastPage {
+		p.OutOfRange = true
+		p.Page = lastPage
+	}
+	return p
+}
+
+// filterReadableResults returns the results in repositories whose code the
+// doer can read
+func filterReadableResults(doer *models.User, results []*indexer.RepoSearchResult) ([]*indexer.RepoSearchResult, error) {
+	repoIDs := make([]int64, 0, len(results))
+	for _, result := range results {
+		repoIDs = append(repoIDs, result.RepoID)
+	}
+	repos, err := models.GetRepositoriesMapByIDs(repoIDs)
+	if err != nil {
+		return nil, err
+	}
+	readable := make(map[int64]bool, len(repos))
+	for repoID, repo := range repos {
+		if readable[repoID], err = models.HasAccessUnit(doer, repo, models.UnitTypeCode, models.AccessModeRead); err != nil {
+			return nil, err
+		}
+	}
+
+	filtered := make([]*indexer.RepoSearchResult, 0, len(results))
+	for _, result := range results {
+		if readable[result.RepoID] {
+			filtered = append(filtered, result)
+		} else {
+			log.Warn("Code search result in repository %d not readable by user %d left out", result.RepoID, doer.ID)
+		}
+	}
+	return filtered, nil
+}
+
+// PerformSearch perform a search on a repository. Returns whether the search
+// timed out, in which case there are no results. The search is stopped if ctx
+// is canceled, e.g. when the client goes away. If doer is not nil, results in
+// repositories whose code the doer can't read are left out, in case the
+// repositories to search were not checked, and are not counted in the total.
+// Results of other pages are not checked, so the repositories to search should
+// still only be the readable ones.
+func PerformSearch(ctx context.Context, doer *models.User, opts *indexer.RepoSearchOptions) (*Pagination, []*Result, bool, error) {
+	if len(opts.Keyword) == 0 {
+		return newPagination(0, opts.Page, opts.PageSize), nil, false, nil
 	}
 
-	total, results, err := indexer.SearchRepoByKeyword(repoIDs, keyword, page, pageSize)
+	total, results, timedOut, err := indexer.SearchRepoByKeyword(ctx, opts)
 	if err != nil {
-		return 0, nil, err
+		return nil, nil, false, err
+	}
+	pagination := newPagination(int(total), opts.Page, opts.PageSize)
+	if pagination.OutOfRange && pagination.Total > 0 {
+		lastPageOpts := *opts
+		lastPageOpts.Page = pagination.Page
+		if _, results, timedOut, err = indexer.SearchRepoByKeyword(ctx, &lastPageOpts); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	if doer != nil {
+		readableResults, err := filterReadableResults(doer, results)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		// the results left out are not counted either
+		if numLeftOut := len(results) - len(readableResults); numLeftOut > 0 {
+			pagination = newPagination(pagination.Total-numLeftOut, pagination.Page, opts.PageSize)
+		}
+		results = readableResults
 	}
 
 	displayResults := make([]*Result, len(results))
 
 	for i, result := range results {
-		startIndex, endIndex := indices(result.Content, result.StartIndex, result.EndIndex)
-		displayResults[i], err = searchResult(result, startIndex, endIndex)
+		displayResults[i], err = searchResult(result, opts.MaxFragments, opts.ExpandToBlock, opts.AllHistory)
 		if err != nil {
-			return 0, nil, err
+			return nil, nil, false, err
 		}
 	}
-	return int(total), displayResults, nil
+	return pagination, displayResults, timedOut, nil
 }
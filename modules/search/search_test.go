@@ -0,0 +1,346 @@
+// Copyright 2018 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/indexer"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlock(t *testing.T, filename, content, match, expected string) {
+	selectionStartIndex := strings.Index(content, match)
+	assert.True(t, selectionStartIndex >= 0)
+	startIndex, endIndex, ok := blockIndices(filename, content,
+		selectionStartIndex, selectionStartIndex+len(match))
+	if expected == "" {
+		assert.False(t, ok)
+		return
+	}
+	if assert.True(t, ok) {
+		assert.Equal(t, expected, content[startIndex:endIndex])
+	}
+}
+
+func TestBlockIndices(t *testing.T) {
+	const goFunc = `func add(a, b int) int {
+	if a == 0 {
+		return b
+	}
+	return a + b
+}`
+	const goContent = "package math\n\n" + goFunc + "\n\nfunc sub(a, b int) int {\n\treturn a - b\n}\n"
+
+	testBlock(t, "math.go", goContent, "a + b", goFunc)
+	// nested blocks are expanded to the function
+	testBlock(t, "math.go", goContent, "return b", goFunc)
+	testBlock(t, "math.go", goContent, "a - b", "func sub(a, b int) int {\n\treturn a - b\n}")
+	// no enclosing block
+	testBlock(t, "math.go", goContent, "package math", "")
+	// unsupported language
+	testBlock(t, "math.txt", goContent, "a + b", "")
+
+	const pyFunc = `def add(a, b):
+    if a == 0:
+        return b
+
+    return a + b`
+	const pyContent = "import os\n\n" + pyFunc + "\n\nprint(add(1, 2))\n"
+
+	testBlock(t, "math.py", pyContent, "a + b", pyFunc)
+	testBlock(t, "math.py", pyContent, "return b", pyFunc)
+	testBlock(t, "math.py", pyContent, "print", "")
+}
+
+func TestBlockIndices_TooLarge(t *testing.T) {
+	content := "func f() {\n" + strings.Repeat("\tx++\n", maxBlockLines) + "\tmatch()\n}\n"
+	testBlock(t, "f.go", content, "match", "")
+}
+
+func TestNewPagination(t *testing.T) {
+	for _, testCase := range []struct {
+		total, page, pageSize int
+		expected              Pagination
+		hasMore               bool
+	}{
+		{0, 1, 10, Pagination{Total: 0, Page: 1, PageSize: 10, TotalPages: 0}, false},
+		{25, 1, 10, Pagination{Total: 25, Page: 1, PageSize: 10, TotalPages: 3}, true},
+		{25, 3, 10, Pagination{Total: 25, Page: 3, PageSize: 10, TotalPages: 3}, false},
+		{30, 3, 10, Pagination{Total: 30, Page: 3, PageSize: 10, TotalPages: 3}, false},
+		{25, 0, 10, Pagination{Total: 25, Page: 1, PageSize: 10, TotalPages: 3}, true},
+		{25, -2, 10, Pagination{Total: 25, Page: 1, PageSize: 10, TotalPages: 3}, true},
+		{25, 7, 10, Pagination{Total: 25, Page: 3, PageSize: 10, TotalPages: 3, OutOfRange: true}, false},
+		{0, 7, 10, Pagination{Total: 0, Page: 1, PageSize: 10, TotalPages: 0, OutOfRange: true}, false},
+	} {
+		p := newPagination(testCase.total, testCase.page, testCase.pageSize)
+		assert.Equal(t, testCase.expected, *p)
+		assert.Equal(t, testCase.hasMore, p.HasMore())
+	}
+}
+
+func TestSearchResult_MaxFragments(t *testing.T) {
+	// 10 matches, each separated by enough lines to be their own fragment
+	var content string
+	var matches []indexer.RepoSearchMatch
+	for i := 0; i < 10; i++ {
+		content += "foo\nfoo\nfoo\n"
+		matches = append(matches, indexer.RepoSearchMatch{
+			StartIndex: len(content),
+			EndIndex:   len(content) + len("match"),
+		})
+		content += "match\nfoo\nfoo\nfoo\n"
+	}
+
+	result, err := searchResult(&indexer.RepoSearchResult{
+		Filename: "matches.txt",
+		Content:  content,
+		Matches:  matches,
+	}, 0, false, false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Fragments, indexer.DefaultMaxFragments)
+	assert.Equal(t, 10-indexer.DefaultMaxFragments, result.NumOmittedFragments)
+	assert.Equal(t, []int{3, 4, 5}, result.Fragments[0].LineNumbers)
+	assert.EqualValues(t,
+		"<li>foo\n</li><li><span class='active'>match</span>\n</li><li>foo</li>",
+		result.Fragments[0].FormattedLines)
+
+	result, err = searchResult(&indexer.RepoSearchResult{
+		Filename: "matches.txt",
+		Content:  content,
+		Matches:  matches,
+	}, 20, false, false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Fragments, 10)
+	assert.Equal(t, 0, result.NumOmittedFragments)
+}
+
+func TestSearchResult_MergedFragments(t *testing.T) {
+	const content = "a\nmatch one match\nb\nc\nmatch\nd\n"
+	result, err := searchResult(&indexer.RepoSearchResult{
+		Filename: "matches.txt",
+		Content:  content,
+		Matches: []indexer.RepoSearchMatch{
+			{StartIndex: 2, EndIndex: 7},
+			{StartIndex: 12, EndIndex: 17},
+			{StartIndex: 22, EndIndex: 27},
+		},
+	}, 0, false, false)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Fragments, 1) {
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, result.Fragments[0].LineNumbers)
+		assert.EqualValues(t,
+			"<li>a\n</li><li><span class='active'>match</span> one <span class='active'>match</span>\n</li>"+
+				"<li>b\n</li><li>c\n</li><li><span class='active'>match</span>\n</li><li>d</li>",
+			result.Fragments[0].FormattedLines)
+	}
+}
+
+func TestSearchResult_Lines(t *testing.T) {
+	const content = "a\nmatch <one> match\nb\n"
+	result, err := searchResult(&indexer.RepoSearchResult{
+		Filename: "matches.txt",
+		Content:  content,
+		Matches: []indexer.RepoSearchMatch{
+			{StartIndex: 2, EndIndex: 7},
+			{StartIndex: 14, EndIndex: 19},
+		},
+	}, 0, false, false)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Fragments, 1) {
+		assert.Equal(t, []*Line{
+			{Number: 1, Content: "a"},
+			{Number: 2, Content: "match <one> match", Matches: []LineMatch{
+				{StartIndex: 0, EndIndex: 5},
+				{StartIndex: 12, EndIndex: 17},
+			}},
+			{Number: 3, Content: "b"},
+		}, result.Fragments[0].Lines)
+		assert.EqualValues(t,
+			"<li>a\n</li><li><span class='active'>match</span> &lt;one&gt; <span class='active'>match</span>\n</li><li>b</li>",
+			result.Fragments[0].FormattedLines)
+	}
+}
+
+func TestIndices(t *testing.T) {
+	for _, testCase := range []struct {
+		content            string
+		selectionStart     int
+		selectionEnd       int
+		expectedStartIndex int
+		expectedEndIndex   int
+	}{
+		// match at the start of the file
+		{"match\nb\nc\n", 0, 5, 0, 7},
+		{"match", 0, 5, 0, 5},
+		// match at the end of the file, with and without trailing newline
+		{"a\nb\nmatch\n", 4, 9, 2, 10},
+		{"a\nb\nmatch", 4, 9, 2, 9},
+		// match in the middle of a line
+		{"a\nb\nx match y\nc\nd\n", 6, 11, 2, 15},
+		// match at the start of a line
+		{"a\nb\nmatch\nc\nd\n", 4, 9, 2, 11},
+		// single line
+		{"x match y", 2, 7, 0, 9},
+	} {
+		startIndex, endIndex := indices(testCase.content, testCase.selectionStart, testCase.selectionEnd)
+		assert.Equal(t, testCase.expectedStartIndex, startIndex, "%q [%d, %d)", testCase.content, testCase.selectionStart, testCase.selectionEnd)
+		assert.Equal(t, testCase.expectedEndIndex, endIndex, "%q [%d, %d)", testCase.content, testCase.selectionStart, testCase.selectionEnd)
+	}
+}
+
+func TestSearchResult_MatchPastEnd(t *testing.T) {
+	// positions of an index entry whose stored content is shorter
+	result, err := searchResult(&indexer.RepoSearchResult{
+		Filename: "main.go",
+		Content:  "func a() {\n\tmatch\n",
+		Matches: []indexer.RepoSearchMatch{
+			{StartIndex: 12, EndIndex: 17},
+			{StartIndex: 30, EndIndex: 35},
+		},
+	}, 0, true, false)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Fragments, 1) {
+		assert.Equal(t, []int{1, 2, 3}, result.Fragments[0].LineNumbers)
+	}
+}
+
+func TestSearchResult_NoMatches(t *testing.T) {
+	result, err := searchResult(&indexer.RepoSearchResult{
+		Filename: "main.go",
+		Content:  "a\nb\nc\n",
+	}, 0, true, false)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Fragments, 1) {
+		assert.Equal(t, []int{1, 2}, result.Fragments[0].LineNumbers)
+		assert.EqualValues(t, "<li>a\n</li><li>b</li>", result.Fragments[0].FormattedLines)
+	}
+	assert.Zero(t, result.NumOmittedFragments)
+}
+
+func TestSearchResult_MatchLineNumber(t *testing.T) {
+	content := "a\nb\nc\nd\nx match y\ne\n"
+	startIndex := strings.Index(content, "match")
+	repoResult := &indexer.RepoSearchResult{
+		RepoID:   1,
+		Filename: "main.go",
+		Content:  content,
+		Matches: []indexer.RepoSearchMatch{
+			{StartIndex: startIndex, EndIndex: startIndex + 5},
+		},
+		LastCommitSha: "65f1bf27bc3bf70f64657658635e66094edbcb4d",
+	}
+	result, err := searchResult(repoResult, 0, false, false)
+	assert.NoError(t, err)
+	// the first line of the fragment is context, the match is on the next one
+	if assert.Len(t, result.Fragments, 1) {
+		assert.Equal(t, 4, result.Fragments[0].LineNumbers[0])
+	}
+	assert.Equal(t, 5, result.MatchLineNumber)
+
+	// anchors are stable, and differ between files and historical versions
+	sameResult, err := searchResult(repoResult, 0, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, result.AnchorID, sameResult.AnchorID)
+	historicalResult, err := searchResult(repoResult, 0, false, true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, result.AnchorID, historicalResult.AnchorID)
+	repoResult.Filename = "other.go"
+	otherResult, err := searchResult(repoResult, 0, false, false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, result.AnchorID, otherResult.AnchorID)
+
+	// results without matches have no match line
+	result, err = searchResult(&indexer.RepoSearchResult{
+		Filename: "main.go",
+		Content:  "a\nb\nc\n",
+	}, 0, false, false)
+	assert.NoError(t, err)
+	assert.Zero(t, result.MatchLineNumber)
+}
+
+func TestFilterReadableResults(t *testing.T) {
+	assert.NoError(t, models.PrepareTestDatabase())
+
+	results := []*indexer.RepoSearchResult{
+		{RepoID: 1, Filename: "a.go"},
+		{RepoID: 2, Filename: "b.go"},
+		{RepoID: 1, Filename: "c.go"},
+	}
+
+	// the private repository 2 is readable by its owner only
+	owner := models.AssertExistsAndLoadBean(t, &models.User{ID: 2}).(*models.User)
+	filtered, err := filterReadableResults(owner, results)
+	assert.NoError(t, err)
+	assert.Equal(t, results, filtered)
+
+	user := models.AssertExistsAndLoadBean(t, &models.User{ID: 4}).(*models.User)
+	filtered, err = filterReadableResults(user, results)
+	assert.NoError(t, err)
+	assert.Equal(t, []*indexer.RepoSearchResult{results[0], results[2]}, filtered)
+}
+
+func TestPerformSearch_LeftOutNotCounted(t *testing.T) {
+	assert.NoError(t, models.PrepareTestDatabase())
+
+	dir, err := ioutil.TempDir("", "repo-indexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oldIndexer := setting.Indexer
+	defer func() {
+		setting.Indexer = oldIndexer
+	}()
+	setting.Indexer.RepoPath = filepath.Join(dir, "repos.bleve")
+	setting.Indexer.RepoHistoryIndexerEnabled = false
+	setting.Indexer.RepoIndexerAnalyzer = "default"
+	indexer.InitRepoIndexer(func() error { return nil }, func() error { return nil })
+	defer func() {
+		assert.NoError(t, indexer.CloseRepoIndexer())
+	}()
+
+	batch := indexer.RepoIndexerBatch()
+	for _, data := range []*indexer.RepoIndexerData{
+		{RepoID: 1, Filename: "a.go", Content: "match"},
+		{RepoID: 2, Filename: "b.go", Content: "match"},
+		{RepoID: 2, Filename: "c.go", Content: "match"},
+	} {
+		assert.NoError(t, indexer.RepoIndexerUpdate{
+			Filepath: data.Filename,
+			Op:       indexer.RepoIndexerOpUpdate,
+			Data:     data,
+		}.AddToFlushingBatch(batch))
+	}
+	assert.NoError(t, batch.Flush())
+
+	// the private repository 2 is readable by its owner only
+	user := models.AssertExistsAndLoadBean(t, &models.User{ID: 4}).(*models.User)
+	pagination, results, _, err := PerformSearch(context.Background(), user, &indexer.RepoSearchOptions{
+		Keyword:  "match",
+		Page:     1,
+		PageSize: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 1, pagination.Total)
+	assert.Equal(t, 1, pagination.TotalPages)
+
+	owner := models.AssertExistsAndLoadBean(t, &models.User{ID: 2}).(*models.User)
+	pagination, results, _, err = PerformSearch(context.Background(), owner, &indexer.RepoSearchOptions{
+		Keyword:  "match",
+		Page:     1,
+		PageSize: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, 3, pagination.Total)
+}
@@ -180,8 +180,22 @@ var (
 		IssuePath          string
 		RepoIndexerEnabled bool
 		RepoPath           string
-		UpdateQueueLength  int
-		MaxIndexerFileSize int64
+		// RepoHistoryIndexerEnabled enables searching past versions of files
+		RepoHistoryIndexerEnabled bool
+		RepoHistoryPath           string
+		// RepoIndexerAnalyzer the name of the analyzer profile of file contents
+		RepoIndexerAnalyzer string
+		// RepoIndexerMetadata the names of the metadata fields extracted from
+		// indexed files
+		RepoIndexerMetadata []string
+		UpdateQueueLength   int
+		MaxIndexerFileSize  int64
+		// RepoIndexerBatchSize and RepoIndexerBatchBytes the maximum number of
+		// operations and, if positive, bytes of file contents of a batch of
+		// the repo indexer
+		RepoIndexerBatchSize  int
+		RepoIndexerBatchBytes int64
+		SearchTimeout         time.Duration
 	}
 
 	// Webhook settings
@@ -1453,10 +1467,13 @@ func NewXORMLogService(disableConsole bool) {
 
 // Cache represents cache settings
 type Cache struct {
-	Adapter  string
-	Interval int
-	Conn     string
-	TTL      time.Duration
+	Adapter             string
+	Interval            int
+	Conn                string
+	TTL                 time.Duration
+	FatalOnStartupError bool
+	// Prefix the prefix of all keys, to share a cache server
+	Prefix string
 }
 
 var (
@@ -1478,6 +1495,8 @@ func newCacheService() {
 		log.Fatal(4, "Unknown cache adapter: %s", CacheService.Adapter)
 	}
 	CacheService.TTL = sec.Key("ITEM_TTL").MustDuration(16 * time.Hour)
+	CacheService.FatalOnStartupError = sec.Key("FATAL_ON_STARTUP_ERROR").MustBool(false)
+	CacheService.Prefix = sec.Key("PREFIX").String()
 
 	log.Info("Cache Service Enabled")
 }
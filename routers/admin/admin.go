@@ -123,6 +123,10 @@ const (
 	reinitMissingRepository
 	syncExternalUsers
 	gitFsck
+	pauseRepoIndexer
+	resumeRepoIndexer
+	reindexAllRepos
+	checkRepoIndexer
 )
 
 // Dashboard show admin panel dashboard
@@ -165,6 +169,18 @@ func Dashboard(ctx *context.Context) {
 		case gitFsck:
 			success = ctx.Tr("admin.dashboard.git_fsck_started")
 			go models.GitFsck()
+		case pauseRepoIndexer:
+			success = ctx.Tr("admin.dashboard.pause_repo_indexer_success")
+			models.PauseRepoIndexer()
+		case resumeRepoIndexer:
+			success = ctx.Tr("admin.dashboard.resume_repo_indexer_success")
+			models.ResumeRepoIndexer()
+		case reindexAllRepos:
+			success = ctx.Tr("admin.dashboard.reindex_all_repos_started")
+			err = models.ReindexAllRepos()
+		case checkRepoIndexer:
+			success = ctx.Tr("admin.dashboard.check_repo_indexer_started")
+			err = models.CheckRepoIndexer()
 		}
 
 		if err != nil {
@@ -177,6 +193,8 @@ func Dashboard(ctx *context.Context) {
 	}
 
 	ctx.Data["Stats"] = models.GetStatistic()
+	ctx.Data["IsRepoIndexerEnabled"] = setting.Indexer.RepoIndexerEnabled
+	ctx.Data["IsRepoIndexerPaused"] = models.IsRepoIndexerPaused()
 	// FIXME: update periodically
 	updateSystemStatus()
 	ctx.Data["SysStatus"] = sysStatus
@@ -192,7 +192,7 @@ func DeleteCollaborator(ctx *context.APIContext) {
 		return
 	}
 
-	if err := ctx.Repo.Repository.DeleteCollaboration(collaborator.ID); err != nil {
+	if err := ctx.Repo.Repository.DeleteCollaborationWithNotify(ctx.User, collaborator); err != nil {
 		ctx.Error(500, "DeleteCollaboration", err)
 		return
 	}
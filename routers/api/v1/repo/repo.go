@@ -553,6 +553,40 @@ func MirrorSync(ctx *context.APIContext) {
 	ctx.Status(200)
 }
 
+// RebuildIndex queues a rebuild of a repository's code search index
+func RebuildIndex(ctx *context.APIContext) {
+	// swagger:operation POST /repos/{owner}/{repo}/index/rebuild repository repoRebuildIndex
+	// ---
+	// summary: Rebuild a repository's code search index
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: owner
+	//   in: path
+	//   description: owner of the repo
+	//   type: string
+	//   required: true
+	// - name: repo
+	//   in: path
+	//   description: name of the repo
+	//   type: string
+	//   required: true
+	// responses:
+	//   "202":
+	//     "$ref": "#/responses/empty"
+	//   "403":
+	//     "$ref": "#/responses/forbidden"
+	//   "404":
+	//     "$ref": "#/responses/notFound"
+	if !setting.Indexer.RepoIndexerEnabled {
+		ctx.Status(404)
+		return
+	}
+
+	models.RebuildRepoIndexer(ctx.Repo.Repository)
+	ctx.Status(202)
+}
+
 // TopicSearch search for creating topic
 func TopicSearch(ctx *context.Context) {
 	// swagger:operation GET /topics/search repository topicSearch
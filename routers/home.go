@@ -6,11 +6,14 @@ package routers
 
 import (
 	"bytes"
+	"html/template"
+	"net/url"
 	"strings"
 
 	"code.gitea.io/gitea/models"
 	"code.gitea.io/gitea/modules/base"
 	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/indexer"
 	"code.gitea.io/gitea/modules/search"
 	"code.gitea.io/gitea/modules/setting"
 	"code.gitea.io/gitea/modules/util"
@@ -259,14 +262,21 @@ func ExploreCode(ctx *context.Context) {
 	ctx.Data["PageIsExploreCode"] = true
 
 	keyword := strings.TrimSpace(ctx.Query("q"))
+	pathPatterns := strings.Fields(ctx.Query("path"))
+	expandToBlock := ctx.QueryBool("block")
+	allHistory := ctx.QueryBool("history") && indexer.IsRepoHistoryIndexerEnabled()
 	page := ctx.QueryInt("page")
 	if page <= 0 {
 		page = 1
 	}
+	fuzziness, err := indexer.ParseFuzziness(ctx.Query("fuzziness"))
+	if err != nil {
+		ctx.Error(400, err.Error())
+		return
+	}
 
 	var (
 		repoIDs []int64
-		err     error
 		isAdmin bool
 		userID  int64
 	)
@@ -275,9 +285,46 @@ func ExploreCode(ctx *context.Context) {
 		isAdmin = ctx.User.IsAdmin
 	}
 
+	// the parameters of the search, kept by the links to the other pages
+	searchQuery := url.Values{"q": {keyword}}
+	if fuzziness != 0 {
+		searchQuery.Set("fuzziness", ctx.Query("fuzziness"))
+	}
+	if len(pathPatterns) > 0 {
+		searchQuery.Set("path", strings.Join(pathPatterns, " "))
+	}
+	if expandToBlock {
+		searchQuery.Set("block", "true")
+	}
+	if allHistory {
+		searchQuery.Set("history", "true")
+	}
+
+	// searching the code of one owner only lists the repositories of the
+	// owner, admins search them all with the owner alone
+	var ownerID int64
+	if ownerName := ctx.Query("owner"); len(ownerName) > 0 {
+		owner, err := models.GetUserByName(ownerName)
+		if err != nil {
+			if models.IsErrUserNotExist(err) {
+				ctx.NotFound("GetUserByName", nil)
+			} else {
+				ctx.ServerError("GetUserByName", err)
+			}
+			return
+		}
+		ownerID = owner.ID
+		ctx.Data["Owner"] = owner
+		searchQuery.Set("owner", owner.Name)
+	}
+
 	// guest user or non-admin user
 	if ctx.User == nil || !isAdmin {
-		repoIDs, err = models.FindUserAccessibleRepoIDs(userID)
+		if ownerID > 0 {
+			repoIDs, err = models.FindUserAccessibleOwnerRepoIDs(userID, ownerID)
+		} else {
+			repoIDs, err = models.FindUserAccessibleRepoIDs(userID)
+		}
 		if err != nil {
 			ctx.ServerError("SearchResults", err)
 			return
@@ -285,8 +332,9 @@ func ExploreCode(ctx *context.Context) {
 	}
 
 	var (
-		total         int
+		pagination    *search.Pagination
 		searchResults []*search.Result
+		timedOut      bool
 	)
 
 	// if non-admin login user, we need check UnitTypeCode at first
@@ -308,14 +356,38 @@ func ExploreCode(ctx *context.Context) {
 
 		ctx.Data["RepoMaps"] = rightRepoMap
 
-		total, searchResults, err = search.PerformSearch(repoIDs, keyword, page, setting.UI.RepoSearchPagingNum)
-		if err != nil {
-			ctx.ServerError("SearchResults", err)
-			return
+		// without repositories with code left, an empty list would search
+		// all of them
+		if len(repoIDs) > 0 {
+			pagination, searchResults, timedOut, err = search.PerformSearch(ctx.Req.Context(), ctx.User, &indexer.RepoSearchOptions{
+				RepoIDs:       repoIDs,
+				OwnerID:       ownerID,
+				Keyword:       keyword,
+				Fuzziness:     fuzziness,
+				PathPatterns:  pathPatterns,
+				ExpandToBlock: expandToBlock,
+				AllHistory:    allHistory,
+				Page:          page,
+				PageSize:      setting.UI.RepoSearchPagingNum,
+			})
+			if err != nil {
+				ctx.ServerError("SearchResults", err)
+				return
+			}
 		}
 		// if non-login user or isAdmin, no need to check UnitTypeCode
 	} else if (ctx.User == nil && len(repoIDs) > 0) || isAdmin {
-		total, searchResults, err = search.PerformSearch(repoIDs, keyword, page, setting.UI.RepoSearchPagingNum)
+		pagination, searchResults, timedOut, err = search.PerformSearch(ctx.Req.Context(), ctx.User, &indexer.RepoSearchOptions{
+			RepoIDs:       repoIDs,
+			OwnerID:       ownerID,
+			Keyword:       keyword,
+			Fuzziness:     fuzziness,
+			PathPatterns:  pathPatterns,
+			ExpandToBlock: expandToBlock,
+			AllHistory:    allHistory,
+			Page:          page,
+			PageSize:      setting.UI.RepoSearchPagingNum,
+		})
 		if err != nil {
 			ctx.ServerError("SearchResults", err)
 			return
@@ -344,10 +416,16 @@ func ExploreCode(ctx *context.Context) {
 		ctx.Data["RepoMaps"] = repoMaps
 	}
 
+	var total int
+	if pagination != nil {
+		total, page = pagination.Total, pagination.Page
+	}
 	ctx.Data["Keyword"] = keyword
+	ctx.Data["SearchQuery"] = template.URL(searchQuery.Encode())
 	pager := paginater.New(total, setting.UI.RepoSearchPagingNum, page, 5)
 	ctx.Data["Page"] = pager
 	ctx.Data["SearchResults"] = searchResults
+	ctx.Data["SearchTimedOut"] = timedOut
 	ctx.Data["RequireHighlightJS"] = true
 	ctx.Data["PageIsViewCode"] = true
 	ctx.HTML(200, tplExploreCode)
@@ -39,7 +39,12 @@ func checkRunMode() {
 func NewServices() {
 	setting.NewServices()
 	mailer.NewContext()
-	cache.NewContext()
+	if err := cache.NewContext(); err != nil {
+		if setting.CacheService.FatalOnStartupError {
+			log.Fatal(4, "Failed to initialize %s cache: %v", setting.CacheService.Adapter, err)
+		}
+		log.Error(4, "Failed to initialize %s cache, it will be unavailable until it can be reached: %v", setting.CacheService.Adapter, err)
+	}
 }
 
 // GlobalInit is for global configuration reload-able.
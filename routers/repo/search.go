@@ -5,11 +5,14 @@
 package repo
 
 import (
+	"html/template"
+	"net/url"
 	"path"
 	"strings"
 
 	"code.gitea.io/gitea/modules/base"
 	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/indexer"
 	"code.gitea.io/gitea/modules/search"
 	"code.gitea.io/gitea/modules/setting"
 
@@ -25,22 +28,54 @@ func Search(ctx *context.Context) {
 		return
 	}
 	keyword := strings.TrimSpace(ctx.Query("q"))
+	pathPatterns := strings.Fields(ctx.Query("path"))
+	expandToBlock := ctx.QueryBool("block")
+	allHistory := ctx.QueryBool("history") && indexer.IsRepoHistoryIndexerEnabled()
 	page := ctx.QueryInt("page")
 	if page <= 0 {
 		page = 1
 	}
-	total, searchResults, err := search.PerformSearch([]int64{ctx.Repo.Repository.ID},
-		keyword, page, setting.UI.RepoSearchPagingNum)
+	fuzziness, err := indexer.ParseFuzziness(ctx.Query("fuzziness"))
+	if err != nil {
+		ctx.Error(400, err.Error())
+		return
+	}
+	pagination, searchResults, timedOut, err := search.PerformSearch(ctx.Req.Context(), ctx.User, &indexer.RepoSearchOptions{
+		RepoIDs:       []int64{ctx.Repo.Repository.ID},
+		Keyword:       keyword,
+		Fuzziness:     fuzziness,
+		PathPatterns:  pathPatterns,
+		ExpandToBlock: expandToBlock,
+		AllHistory:    allHistory,
+		Page:          page,
+		PageSize:      setting.UI.RepoSearchPagingNum,
+	})
 	if err != nil {
 		ctx.ServerError("SearchResults", err)
 		return
 	}
 	ctx.Data["Keyword"] = keyword
-	pager := paginater.New(total, setting.UI.RepoSearchPagingNum, page, 5)
+	// the parameters of the search, kept by the links to the other pages
+	searchQuery := url.Values{"q": {keyword}}
+	if fuzziness != 0 {
+		searchQuery.Set("fuzziness", ctx.Query("fuzziness"))
+	}
+	if len(pathPatterns) > 0 {
+		searchQuery.Set("path", strings.Join(pathPatterns, " "))
+	}
+	if expandToBlock {
+		searchQuery.Set("block", "true")
+	}
+	if allHistory {
+		searchQuery.Set("history", "true")
+	}
+	ctx.Data["SearchQuery"] = template.URL(searchQuery.Encode())
+	pager := paginater.New(pagination.Total, setting.UI.RepoSearchPagingNum, pagination.Page, 5)
 	ctx.Data["Page"] = pager
 	ctx.Data["SourcePath"] = setting.AppSubURL + "/" +
 		path.Join(ctx.Repo.Repository.Owner.Name, ctx.Repo.Repository.Name, "src", "branch", ctx.Repo.Repository.DefaultBranch)
 	ctx.Data["SearchResults"] = searchResults
+	ctx.Data["SearchTimedOut"] = timedOut
 	ctx.Data["RequireHighlightJS"] = true
 	ctx.Data["PageIsViewCode"] = true
 	ctx.HTML(200, tplSearch)
@@ -36,6 +36,8 @@ const (
 func Settings(ctx *context.Context) {
 	ctx.Data["Title"] = ctx.Tr("repo.settings")
 	ctx.Data["PageIsSettingsOptions"] = true
+	ctx.Data["IsRepoIndexerEnabled"] = setting.Indexer.RepoIndexerEnabled
+	ctx.Data["MaxIndexerFileSize"] = setting.Indexer.MaxIndexerFileSize
 	ctx.HTML(200, tplSettingsOptions)
 }
 
@@ -43,6 +45,8 @@ func Settings(ctx *context.Context) {
 func SettingsPost(ctx *context.Context, form auth.RepoSettingForm) {
 	ctx.Data["Title"] = ctx.Tr("repo.settings")
 	ctx.Data["PageIsSettingsOptions"] = true
+	ctx.Data["IsRepoIndexerEnabled"] = setting.Indexer.RepoIndexerEnabled
+	ctx.Data["MaxIndexerFileSize"] = setting.Indexer.MaxIndexerFileSize
 
 	repo := ctx.Repo.Repository
 
@@ -53,6 +57,14 @@ func SettingsPost(ctx *context.Context, form auth.RepoSettingForm) {
 			return
 		}
 
+		// Only site admins may change how much of a repository is indexed.
+		canChangeIndexer := setting.Indexer.RepoIndexerEnabled && ctx.User.IsAdmin
+		if canChangeIndexer && form.IndexerMaxFileSize < 0 {
+			ctx.Data["Err_IndexerMaxFileSize"] = true
+			ctx.RenderWithErr(ctx.Tr("repo.settings.indexer_max_file_size_invalid"), tplSettingsOptions, &form)
+			return
+		}
+
 		isNameChanged := false
 		oldRepoName := repo.Name
 		newRepoName := form.RepoName
@@ -93,12 +105,21 @@ func SettingsPost(ctx *context.Context, form auth.RepoSettingForm) {
 			form.Private = repo.BaseRepo.IsPrivate
 		}
 
+		indexerMaxFileSizeChanged := false
+		if canChangeIndexer {
+			indexerMaxFileSizeChanged = repo.IndexerMaxFileSize != form.IndexerMaxFileSize
+			repo.IndexerMaxFileSize = form.IndexerMaxFileSize
+		}
+
 		visibilityChanged := repo.IsPrivate != form.Private
 		repo.IsPrivate = form.Private
 		if err := models.UpdateRepository(repo, visibilityChanged); err != nil {
 			ctx.ServerError("UpdateRepository", err)
 			return
 		}
+		if indexerMaxFileSizeChanged {
+			models.RebuildRepoIndexer(repo)
+		}
 		log.Trace("Repository basic settings updated: %s/%s", ctx.Repo.Owner.Name, repo.Name)
 
 		if isNameChanged {
@@ -435,7 +456,10 @@ func ChangeCollaborationAccessMode(ctx *context.Context) {
 
 // DeleteCollaboration delete a collaboration for a repository
 func DeleteCollaboration(ctx *context.Context) {
-	if err := ctx.Repo.Repository.DeleteCollaboration(ctx.QueryInt64("id")); err != nil {
+	u, err := models.GetUserByID(ctx.QueryInt64("id"))
+	if err != nil {
+		ctx.Flash.Error("GetUserByID: " + err.Error())
+	} else if err = ctx.Repo.Repository.DeleteCollaborationWithNotify(ctx.User, u); err != nil {
 		ctx.Flash.Error("DeleteCollaboration: " + err.Error())
 	} else {
 		ctx.Flash.Success(ctx.Tr("repo.settings.remove_collaborator_success"))
@@ -11,8 +11,10 @@ import (
 	"code.gitea.io/gitea/models"
 	"code.gitea.io/gitea/modules/auth"
 	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
 	"code.gitea.io/gitea/modules/test"
 
+	"github.com/Unknwon/com"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -185,3 +187,31 @@ func TestCollaborationPost_NonExistentUser(t *testing.T) {
 	assert.EqualValues(t, http.StatusFound, ctx.Resp.Status())
 	assert.NotEmpty(t, ctx.Flash.ErrorMsg)
 }
+
+func TestSettingsPost_InvalidIndexerMaxFileSize(t *testing.T) {
+	models.PrepareTestEnv(t)
+
+	oldEnabled := setting.Indexer.RepoIndexerEnabled
+	defer func() { setting.Indexer.RepoIndexerEnabled = oldEnabled }()
+	setting.Indexer.RepoIndexerEnabled = true
+
+	ctx := test.MockContext(t, "user2/repo1/settings")
+	ctx.Req.Form.Set("action", "update")
+	test.LoadUser(t, ctx, 1)
+	test.LoadRepo(t, ctx, 1)
+	test.LoadGitRepo(t, ctx)
+	ctx.Repo.Owner = ctx.Repo.Repository.Owner
+
+	SettingsPost(ctx, auth.RepoSettingForm{
+		RepoName:           "repo1-renamed",
+		IndexerMaxFileSize: -1,
+	})
+	assert.EqualValues(t, http.StatusOK, ctx.Resp.Status())
+	assert.True(t, ctx.Data["Err_IndexerMaxFileSize"].(bool))
+
+	// the invalid form is rejected before the repository is renamed
+	models.AssertExistsAndLoadBean(t, &models.Repository{ID: 1, Name: "repo1"})
+	models.AssertNotExistsBean(t, &models.RepoRedirect{OwnerID: 2, LowerName: "repo1"})
+	assert.True(t, com.IsDir(models.RepoPath("user2", "repo1")))
+	assert.False(t, com.IsExist(models.RepoPath("user2", "repo1-renamed")))
+}
@@ -13,6 +13,7 @@ import (
 
 	"code.gitea.io/gitea/models"
 	"code.gitea.io/gitea/modules/auth"
+	"code.gitea.io/gitea/modules/cache"
 	"code.gitea.io/gitea/modules/context"
 	"code.gitea.io/gitea/modules/lfs"
 	"code.gitea.io/gitea/modules/log"
@@ -33,7 +34,6 @@ import (
 	userSetting "code.gitea.io/gitea/routers/user/setting"
 
 	"github.com/go-macaron/binding"
-	"github.com/go-macaron/cache"
 	"github.com/go-macaron/captcha"
 	"github.com/go-macaron/csrf"
 	"github.com/go-macaron/gzip"
@@ -108,11 +108,11 @@ func NewMacaron() *macaron.Macaron {
 		DefaultLang: "en-US",
 		Redirect:    true,
 	}))
-	m.Use(cache.Cacher(cache.Options{
-		Adapter:       setting.CacheService.Adapter,
-		AdapterConfig: setting.CacheService.Conn,
-		Interval:      setting.CacheService.Interval,
-	}))
+	// share the cache started by routers.NewServices rather than starting
+	// the adapter a second time
+	m.Use(func(ctx *macaron.Context) {
+		ctx.Map(cache.GetCache())
+	})
 	m.Use(captcha.Captchaer(captcha.Options{
 		SubURL: setting.AppSubURL,
 	}))